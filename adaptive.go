@@ -0,0 +1,92 @@
+package httpencoder
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// AdaptiveLevel configures compression level selection for single coding
+	// of LeveledEncoder based on its load: IdleLevel is used until average
+	// encode duration exceeds MaxLatency or number of in-flight encodes
+	// exceeds MaxInFlight, then BusyLevel is used. Levels are passed to
+	// encoder as is, so zero is level zero of coding, use DefaultLevel for
+	// encoder's default. Zero MaxLatency or MaxInFlight disables that check.
+	AdaptiveLevel struct {
+		IdleLevel   int
+		BusyLevel   int
+		MaxLatency  time.Duration
+		MaxInFlight int
+	}
+
+	levelController struct {
+		settings AdaptiveLevel
+		inFlight atomic.Int64
+		average  atomic.Int64
+	}
+)
+
+// averageWeight is reciprocal of weight of new encode duration in moving average.
+const averageWeight = 8
+
+// WithAdaptiveLevel makes middleware pick compression level for LeveledEncoder
+// dynamically based on measured encode durations and in-flight encodes of
+// its coding, since levels and speed differ between codecs, e.g.
+// {"gzip": {IdleLevel: 6, BusyLevel: 1}, "br": {IdleLevel: 5, BusyLevel: 1}}.
+// Codings without settings use DefaultLevel. Level set by handler with
+// SetLevel takes precedence.
+func WithAdaptiveLevel(settings map[string]AdaptiveLevel) Option {
+	return func(cfg *config) {
+		cfg.adaptiveLevels = make(map[string]*levelController, len(settings))
+
+		for coding, codingSettings := range settings {
+			cfg.adaptiveLevels[strings.ToLower(strings.TrimSpace(coding))] = &levelController{
+				settings: codingSettings,
+				inFlight: atomic.Int64{},
+				average:  atomic.Int64{},
+			}
+		}
+	}
+}
+
+// adaptiveLevel returns level of coding picked by its controller, or DefaultLevel.
+func (cfg *config) adaptiveLevel(coding string) int {
+	controller, exist := cfg.adaptiveLevels[coding]
+	if !exist {
+		return DefaultLevel
+	}
+
+	return controller.level()
+}
+
+func (controller *levelController) level() int {
+	settings := controller.settings
+
+	if settings.MaxInFlight > 0 && controller.inFlight.Load() >= int64(settings.MaxInFlight) {
+		return settings.BusyLevel
+	}
+
+	if settings.MaxLatency > 0 && time.Duration(controller.average.Load()) > settings.MaxLatency {
+		return settings.BusyLevel
+	}
+
+	return settings.IdleLevel
+}
+
+func (controller *levelController) start() {
+	controller.inFlight.Add(1)
+}
+
+func (controller *levelController) done(duration time.Duration) {
+	controller.inFlight.Add(-1)
+
+	for {
+		average := controller.average.Load()
+		updated := average + (int64(duration)-average)/averageWeight
+
+		if controller.average.CompareAndSwap(average, updated) {
+			return
+		}
+	}
+}
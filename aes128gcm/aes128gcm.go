@@ -0,0 +1,290 @@
+// Package aes128gcm implements "aes128gcm" encrypted content coding
+// (RFC 8188) as httpencoder.Encoder and httpencoder.Decoder.
+package aes128gcm
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type (
+	// KeyProvider returns input keying material by keyid header field.
+	KeyProvider interface {
+		Key(ctx context.Context, keyID string) ([]byte, error)
+	}
+
+	// StaticKeys is KeyProvider with fixed set of keys.
+	StaticKeys map[string][]byte
+
+	// Codec encrypts and decrypts bodies with "aes128gcm" content coding.
+	Codec struct {
+		// Keys provides keys for encryption and decryption.
+		Keys KeyProvider
+		// Rand is source of salt, crypto/rand.Reader if nil.
+		Rand io.Reader
+		// KeyID is keyid used for encryption, up to 255 bytes.
+		KeyID string
+		// RecordSize is record size used for encryption, DefaultRecordSize if zero.
+		RecordSize uint32
+	}
+)
+
+const (
+	// Coding is content coding name.
+	Coding = "aes128gcm"
+	// DefaultRecordSize is record size used by Codec with zero RecordSize.
+	DefaultRecordSize = 4096
+
+	saltSize      = 16
+	keySize       = 16
+	nonceSize     = 12
+	tagSize       = 16
+	headerSize    = saltSize + 4 + 1
+	minRecordSize = tagSize + 2
+	maxKeyIDSize  = 255
+
+	delimiterRecord     = 0x01
+	delimiterLastRecord = 0x02
+)
+
+var (
+	// ErrUnknownKey is returned by StaticKeys for unknown keyid.
+	ErrUnknownKey = errors.New("aes128gcm: unknown key")
+	// ErrInvalidHeader is returned for malformed header or unsupported parameters.
+	ErrInvalidHeader = errors.New("aes128gcm: invalid header")
+	// ErrInvalidRecord is returned for malformed, truncated or forged records.
+	ErrInvalidRecord = errors.New("aes128gcm: invalid record")
+)
+
+// New returns Codec, which encrypts bodies with key provided for keyID.
+func New(keys KeyProvider, keyID string) *Codec {
+	return &Codec{
+		Keys:       keys,
+		Rand:       nil,
+		KeyID:      keyID,
+		RecordSize: DefaultRecordSize,
+	}
+}
+
+// Key returns key by keyID.
+func (keys StaticKeys) Key(_ context.Context, keyID string) ([]byte, error) {
+	key, exist := keys[keyID]
+	if !exist {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	return key, nil
+}
+
+// Encode encrypts from into to.
+func (codec *Codec) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return codec.EncodePadded(ctx, to, from, 0)
+}
+
+// Encrypts reports true, so Codec is httpencoder.EncryptingEncoder and
+// middleware never sends body unencrypted instead.
+func (*Codec) Encrypts() bool {
+	return true
+}
+
+// EncodePadded encrypts from into to with padding zero bytes added to
+// hide body length, so Codec is httpencoder.PaddingEncoder.
+func (codec *Codec) EncodePadded(ctx context.Context, to io.Writer, from []byte, padding int) error {
+	recordSize := codec.RecordSize
+	if recordSize == 0 {
+		recordSize = DefaultRecordSize
+	}
+
+	if recordSize < minRecordSize || len(codec.KeyID) > maxKeyIDSize {
+		return ErrInvalidHeader
+	}
+
+	key, err := codec.Keys.Key(ctx, codec.KeyID)
+	if err != nil {
+		return fmt.Errorf("aes128gcm: failed to get key: %w", err)
+	}
+
+	random := codec.Rand
+	if random == nil {
+		random = rand.Reader
+	}
+
+	header := make([]byte, headerSize, headerSize+len(codec.KeyID))
+
+	_, err = io.ReadFull(random, header[:saltSize])
+	if err != nil {
+		return fmt.Errorf("aes128gcm: failed to generate salt: %w", err)
+	}
+
+	binary.BigEndian.PutUint32(header[saltSize:], recordSize)
+	header[saltSize+4] = byte(len(codec.KeyID))
+	header = append(header, codec.KeyID...)
+
+	aead, nonce, err := newCipher(key, header[:saltSize])
+	if err != nil {
+		return err
+	}
+
+	_, err = to.Write(header)
+	if err != nil {
+		return fmt.Errorf("aes128gcm: %w", err)
+	}
+
+	return encryptRecords(aead, nonce, to, from, int(recordSize)-tagSize-1, padding)
+}
+
+// Decode decrypts from into to.
+func (codec *Codec) Decode(ctx context.Context, to io.Writer, from []byte) error {
+	if len(from) < headerSize || len(from) < headerSize+int(from[saltSize+4]) {
+		return ErrInvalidHeader
+	}
+
+	salt := from[:saltSize]
+	recordSize := int(binary.BigEndian.Uint32(from[saltSize:]))
+	keyID := string(from[headerSize : headerSize+int(from[saltSize+4])])
+	records := from[headerSize+len(keyID):]
+
+	if recordSize < minRecordSize {
+		return ErrInvalidHeader
+	}
+
+	key, err := codec.Keys.Key(ctx, keyID)
+	if err != nil {
+		return fmt.Errorf("aes128gcm: failed to get key: %w", err)
+	}
+
+	aead, nonce, err := newCipher(key, salt)
+	if err != nil {
+		return err
+	}
+
+	return decryptRecords(aead, nonce, to, records, recordSize)
+}
+
+func encryptRecords(aead cipher.AEAD, nonce []byte, to io.Writer, from []byte, chunkSize, padding int) error {
+	record := make([]byte, 0, chunkSize+1+tagSize)
+
+	for seq := uint64(0); ; seq++ {
+		chunk := from
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		from = from[len(chunk):]
+		last := len(from) == 0 && len(chunk)+padding <= chunkSize
+
+		plaintext := append(record[:0], chunk...)
+
+		if last {
+			plaintext = append(plaintext, delimiterLastRecord)
+			plaintext = append(plaintext, make([]byte, padding)...)
+		} else {
+			// fill record with padding up to record size, if body is over
+			paddingSize := chunkSize - len(chunk)
+			padding -= paddingSize
+
+			plaintext = append(plaintext, delimiterRecord)
+			plaintext = append(plaintext, make([]byte, paddingSize)...)
+		}
+
+		_, err := to.Write(aead.Seal(plaintext[:0], recordNonce(nonce, seq), plaintext, nil))
+		if err != nil {
+			return fmt.Errorf("aes128gcm: %w", err)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+func decryptRecords(aead cipher.AEAD, nonce []byte, to io.Writer, records []byte, recordSize int) error {
+	for seq := uint64(0); ; seq++ {
+		record := records
+		if len(record) > recordSize {
+			record = record[:recordSize]
+		}
+
+		records = records[len(record):]
+
+		plaintext, err := aead.Open(nil, recordNonce(nonce, seq), record, nil)
+		if err != nil {
+			return ErrInvalidRecord
+		}
+
+		// strip padding zeros up to delimiter
+		end := len(plaintext) - 1
+		for end >= 0 && plaintext[end] == 0 {
+			end--
+		}
+
+		last := len(records) == 0
+
+		switch {
+		case end < 0:
+			return ErrInvalidRecord
+		case last && plaintext[end] != delimiterLastRecord:
+			return ErrInvalidRecord
+		case !last && plaintext[end] != delimiterRecord:
+			return ErrInvalidRecord
+		}
+
+		_, err = to.Write(plaintext[:end])
+		if err != nil {
+			return fmt.Errorf("aes128gcm: %w", err)
+		}
+
+		if last {
+			return nil
+		}
+	}
+}
+
+// newCipher derives content encryption key and nonce with HKDF-SHA-256.
+func newCipher(key, salt []byte) (cipher.AEAD, []byte, error) {
+	prk := hmacSHA256(salt, key)
+	cek := hmacSHA256(prk, []byte("Content-Encoding: aes128gcm\x00\x01"))[:keySize]
+	nonce := hmacSHA256(prk, []byte("Content-Encoding: nonce\x00\x01"))[:nonceSize]
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, fmt.Errorf("aes128gcm: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("aes128gcm: %w", err)
+	}
+
+	return aead, nonce, nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+// recordNonce returns nonce XOR record sequence number.
+func recordNonce(nonce []byte, seq uint64) []byte {
+	var seqBytes [nonceSize]byte
+
+	binary.BigEndian.PutUint64(seqBytes[nonceSize-8:], seq)
+
+	result := bytes.Clone(nonce)
+	for i := range result {
+		result[i] ^= seqBytes[i]
+	}
+
+	return result
+}
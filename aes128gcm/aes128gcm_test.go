@@ -0,0 +1,272 @@
+package aes128gcm_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+	"github.com/alexdyukov/httpencoder/aes128gcm"
+)
+
+type reverser struct{}
+
+func (reverser) Encode(_ context.Context, to io.Writer, from []byte) error {
+	reversed := make([]byte, len(from))
+	for i := range from {
+		reversed[len(from)-i-1] = from[i]
+	}
+
+	_, err := to.Write(reversed)
+
+	return err
+}
+
+func (reverser) Decode(ctx context.Context, to io.Writer, from []byte) error {
+	return reverser{}.Encode(ctx, to, from)
+}
+
+func mustDecode(test *testing.T, encoded string) []byte {
+	test.Helper()
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	return decoded
+}
+
+// Test vectors from RFC 8188 section 3.
+func TestRFCExamples(test *testing.T) {
+	test.Parallel()
+
+	tests := []struct {
+		testName   string
+		key        string
+		keyID      string
+		ciphertext string
+	}{
+		{
+			testName:   "encryption of single record",
+			key:        "yqdlZ-tYemfogSmv7Ws5PQ",
+			keyID:      "",
+			ciphertext: "I1BsxtFttlv3u_Oo94xnmwAAEAAA-NAVub2qFgBEuQKRapoZu-IxkIva3MEB1PD-ly8Thjg",
+		}, {
+			testName: "encryption with multiple records",
+			key:      "BO3ZVPxUlnLORbVGMpbT1Q",
+			keyID:    "a1",
+			ciphertext: "uNCkWiNYzKTnBN9ji3-qWAAAABkCYTHOG8chz_gnvgOqdGYovxyjuqRyJFjEDyoF1Fvkj6hQPdPHI51OEUKEpgz3SsLWIqS_" +
+				"uA",
+		},
+	}
+
+	for _, iterTest := range tests {
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			ciphertext := mustDecode(t, iterTest.ciphertext)
+			codec := aes128gcm.New(aes128gcm.StaticKeys{iterTest.keyID: mustDecode(t, iterTest.key)}, iterTest.keyID)
+
+			plaintext := &bytes.Buffer{}
+
+			err := codec.Decode(context.Background(), plaintext, ciphertext)
+			if err != nil {
+				t.Fatal("cannot decrypt: " + err.Error())
+			}
+
+			if plaintext.String() != "I am the walrus" {
+				t.Fatalf("invalid plaintext: '%s'", plaintext)
+			}
+
+			if iterTest.keyID != "" {
+				return
+			}
+
+			codec.Rand = bytes.NewReader(ciphertext[:16])
+			encrypted := &bytes.Buffer{}
+
+			err = codec.Encode(context.Background(), encrypted, plaintext.Bytes())
+			if err != nil {
+				t.Fatal("cannot encrypt: " + err.Error())
+			}
+
+			if !bytes.Equal(encrypted.Bytes(), ciphertext) {
+				t.Fatalf("invalid ciphertext: want %v but got %v", ciphertext, encrypted.Bytes())
+			}
+		})
+	}
+}
+
+func TestRoundTrip(test *testing.T) {
+	test.Parallel()
+
+	keys := aes128gcm.StaticKeys{"k": bytes.Repeat([]byte{1}, 16)}
+	codec := &aes128gcm.Codec{Keys: keys, Rand: nil, KeyID: "k", RecordSize: 20}
+
+	for _, size := range []int{0, 1, 3, 4, 5, 100} {
+		for _, padding := range []int{0, 1, 10} {
+			plaintext := bytes.Repeat([]byte{'x'}, size)
+			encrypted := &bytes.Buffer{}
+			decrypted := &bytes.Buffer{}
+
+			err := codec.EncodePadded(context.Background(), encrypted, plaintext, padding)
+			if err != nil {
+				test.Fatal("cannot encrypt: " + err.Error())
+			}
+
+			err = codec.Decode(context.Background(), decrypted, encrypted.Bytes())
+			if err != nil {
+				test.Fatalf("cannot decrypt %d bytes with %d padding: %s", size, padding, err.Error())
+			}
+
+			if !bytes.Equal(decrypted.Bytes(), plaintext) {
+				test.Fatalf("invalid round trip of %d bytes with %d padding", size, padding)
+			}
+		}
+	}
+
+	encrypted := &bytes.Buffer{}
+	_ = codec.Encode(context.Background(), encrypted, []byte("body"))
+
+	forged := encrypted.Bytes()
+	forged[len(forged)-1] ^= 1
+
+	if err := codec.Decode(context.Background(), io.Discard, forged); !errors.Is(err, aes128gcm.ErrInvalidRecord) {
+		test.Fatalf("forged record must be rejected, got %v", err)
+	}
+}
+
+func TestStackedWithCompression(test *testing.T) {
+	test.Parallel()
+
+	codec := aes128gcm.New(aes128gcm.StaticKeys{"": bytes.Repeat([]byte{2}, 16)}, "")
+	compress := httpencoder.New(
+		map[string]httpencoder.Encoder{"reverse": reverser{}},
+		map[string]httpencoder.Decoder{"reverse": reverser{}, aes128gcm.Coding: codec},
+		httpencoder.WithStackedEncoder(aes128gcm.Coding, codec),
+	)
+
+	handler := compress(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, _ = io.Copy(responseWriter, request.Body)
+	}))
+
+	body := &bytes.Buffer{}
+	_ = reverser{}.Encode(context.Background(), body, []byte("secret"))
+
+	encrypted := &bytes.Buffer{}
+	_ = codec.Encode(context.Background(), encrypted, body.Bytes())
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/", encrypted)
+	request.Header.Set("Content-Encoding", "reverse, aes128gcm")
+	request.Header.Set("Accept-Encoding", "reverse, aes128gcm")
+
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Header().Get("Content-Encoding") != "reverse, aes128gcm" {
+		test.Fatalf("invalid Content-Encoding header in response: %s", recorder.Header().Get("Content-Encoding"))
+	}
+
+	decrypted := &bytes.Buffer{}
+
+	err := codec.Decode(context.Background(), decrypted, recorder.Body.Bytes())
+	if err != nil {
+		test.Fatal("cannot decrypt response: " + err.Error())
+	}
+
+	if !strings.EqualFold(decrypted.String(), "terces") {
+		test.Fatalf("invalid decrypted response: '%s'", decrypted)
+	}
+}
+
+func TestNotDroppedWithoutSavings(test *testing.T) {
+	test.Parallel()
+
+	codec := aes128gcm.New(aes128gcm.StaticKeys{"": bytes.Repeat([]byte{3}, 16)}, "")
+
+	tests := []struct {
+		testName       string
+		acceptEncoding string
+		opts           []httpencoder.Option
+		encoders       map[string]httpencoder.Encoder
+		contentCoding  string
+	}{
+		{
+			testName:       "encryption alone",
+			acceptEncoding: aes128gcm.Coding,
+			opts:           []httpencoder.Option{httpencoder.WithMinSavings(0)},
+			encoders:       map[string]httpencoder.Encoder{aes128gcm.Coding: codec},
+			contentCoding:  aes128gcm.Coding,
+		}, {
+			testName:       "stacked encryption after skipped compression",
+			acceptEncoding: "reverse, aes128gcm",
+			opts: []httpencoder.Option{
+				httpencoder.WithMinSavings(0), httpencoder.WithStackedEncoder(aes128gcm.Coding, codec),
+			},
+			encoders:      map[string]httpencoder.Encoder{"reverse": reverser{}},
+			contentCoding: aes128gcm.Coding,
+		}, {
+			testName:       "stacked encryption of too small response",
+			acceptEncoding: "reverse, aes128gcm",
+			opts: []httpencoder.Option{
+				httpencoder.WithPolicy(func(*http.Request) httpencoder.Policy {
+					return httpencoder.Policy{
+						Encoders:           nil,
+						Decoders:           nil,
+						MaxRequestBodySize: 0,
+						MaxDecodedSize:     0,
+						MinSize:            1024,
+						DisableEncode:      false,
+						DisableDecode:      false,
+					}
+				}),
+				httpencoder.WithStackedEncoder(aes128gcm.Coding, codec),
+			},
+			encoders:      map[string]httpencoder.Encoder{"reverse": reverser{}},
+			contentCoding: aes128gcm.Coding,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			handler := httpencoder.New(iterTest.encoders, nil, iterTest.opts...)(
+				http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+					_, _ = responseWriter.Write([]byte("secret"))
+				}),
+			)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.contentCoding {
+				t.Fatalf("invalid Content-Encoding header in response, want %s but got %s",
+					iterTest.contentCoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			decrypted := &bytes.Buffer{}
+
+			err := codec.Decode(context.Background(), decrypted, recorder.Body.Bytes())
+			if err != nil {
+				t.Fatal("cannot decrypt response: " + err.Error())
+			}
+
+			if decrypted.String() != "secret" {
+				t.Fatalf("invalid decrypted response, want secret but got %s", decrypted)
+			}
+		})
+	}
+}
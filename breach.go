@@ -0,0 +1,129 @@
+package httpencoder
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"math/big"
+	"net/http"
+	"regexp"
+)
+
+type (
+	// PaddingEncoder implements Encoder which can hide length of encoded
+	// body with padding, like aes128gcm content coding of RFC 8188.
+	PaddingEncoder interface {
+		Encoder
+		// EncodePadded encodes http.ResponseWriter body with provided number of padding bytes.
+		EncodePadded(ctx context.Context, to io.Writer, from []byte, padding int) error
+	}
+
+	// BreachMitigation configures handling of sensitive responses, which
+	// reflect user input next to secrets, like CSRF tokens, and so are
+	// vulnerable to BREACH attack when compressed. Response is sensitive if
+	// handler called MarkSensitive or its body matches any of Patterns.
+	// Sensitive response is sent as is, unless Pad is set and negotiated
+	// encoder or accepted stacked encoder, like aes128gcm after gzip, is
+	// PaddingEncoder: then it is encoded with random padding up to
+	// MaxPadding bytes, or DefaultMaxPadding if MaxPadding is zero, so
+	// compressed body length is hidden by padding of encryption.
+	// Sensitive response of EncryptingEncoder is encrypted without padding
+	// if padding is not enabled, since encryption alone does not compress.
+	BreachMitigation struct {
+		Patterns   []*regexp.Regexp
+		MaxPadding int
+		Pad        bool
+	}
+
+	paddedEncoder struct {
+		PaddingEncoder
+		padding int
+	}
+)
+
+// DefaultMaxPadding is upper bound of random padding used when BreachMitigation.MaxPadding is zero.
+const DefaultMaxPadding = 256
+
+// WithBreachMitigation enables detection of sensitive responses by body
+// patterns and their padding instead of sending them as is.
+func WithBreachMitigation(mitigation BreachMitigation) Option {
+	return func(cfg *config) {
+		if mitigation.MaxPadding <= 0 {
+			mitigation.MaxPadding = DefaultMaxPadding
+		}
+
+		cfg.breach = mitigation
+	}
+}
+
+// MarkSensitive marks current response as vulnerable to BREACH attack, so
+// it is not compressed as is, see WithBreachMitigation. MarkSensitive
+// reports whether middleware's http.ResponseWriter was found.
+func MarkSensitive(responseWriter http.ResponseWriter) bool {
+	wrapped := findWrappedWriter(responseWriter)
+	if wrapped == nil {
+		return false
+	}
+
+	wrapped.sensitive = true
+
+	return true
+}
+
+func (cfg *config) isSensitive(wrapped *wrappedWriter, body []byte) bool {
+	if wrapped.sensitive {
+		return true
+	}
+
+	for _, pattern := range cfg.breach.Patterns {
+		if pattern.Match(body) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withPadding returns encoder which adds random padding with its size, or nil
+// if encoder cannot pad or padding is not enabled.
+//
+//nolint:ireturn // helper function
+func (cfg *config) withPadding(encoder Encoder) (Encoder, int) {
+	paddingEncoder, okay := encoder.(PaddingEncoder)
+	if !okay || !cfg.breach.Pad {
+		return nil, 0
+	}
+
+	random, err := rand.Int(rand.Reader, big.NewInt(int64(cfg.breach.MaxPadding)))
+	if err != nil {
+		return nil, 0
+	}
+
+	padding := int(random.Int64()) + 1
+
+	return paddedEncoder{PaddingEncoder: paddingEncoder, padding: padding}, padding
+}
+
+//nolint:wrapcheck // there is simple padding wrapper, no need to wrap
+func (encoder paddedEncoder) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return encoder.EncodePadded(ctx, to, from, encoder.padding)
+}
+
+// withStackedPadding returns stacked encoder with random padding and its size,
+// or nil if stacked encoder is not applied on top of negotiated encoding or
+// cannot pad.
+//
+//nolint:ireturn // helper function
+func (cfg *config) withStackedPadding(acceptEncodingHeader []byte, encodingType string) (Encoder, int) {
+	if encodingType == cfg.stackedCoding || !cfg.acceptsStacked(acceptEncodingHeader) {
+		return nil, 0
+	}
+
+	return cfg.withPadding(cfg.stackedEncoder)
+}
+
+func (cfg *config) padded(ctx context.Context, coding string, padding int) {
+	if cfg.hooks.OnPadded != nil {
+		cfg.hooks.OnPadded(ctx, coding, padding)
+	}
+}
@@ -0,0 +1,156 @@
+package httpencoder_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+	"github.com/alexdyukov/httpencoder/aes128gcm"
+)
+
+func TestBreachMitigation(test *testing.T) {
+	test.Parallel()
+
+	codec := aes128gcm.New(aes128gcm.StaticKeys{"": bytes.Repeat([]byte{4}, 16)}, "")
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}, "half": halver{}, aes128gcm.Coding: codec}
+
+	halved := &bytes.Buffer{}
+	_ = halver{}.Encode(context.Background(), halved, []byte(testString))
+
+	tests := []struct {
+		testName         string
+		acceptEncoding   string
+		markSensitive    bool
+		mitigation       *httpencoder.BreachMitigation
+		responseEncoding string
+		padded           bool
+		plaintext        string
+	}{
+		{
+			testName:         "not sensitive response",
+			acceptEncoding:   "repeate",
+			markSensitive:    false,
+			mitigation:       &httpencoder.BreachMitigation{Patterns: []*regexp.Regexp{regexp.MustCompile("csrf")}},
+			responseEncoding: "repeate",
+			padded:           false,
+			plaintext:        "",
+		}, {
+			testName:         "marked sensitive without mitigation",
+			acceptEncoding:   "repeate",
+			markSensitive:    true,
+			mitigation:       nil,
+			responseEncoding: "",
+			padded:           false,
+			plaintext:        "",
+		}, {
+			testName:         "matched pattern",
+			acceptEncoding:   "repeate",
+			markSensitive:    false,
+			mitigation:       &httpencoder.BreachMitigation{Patterns: []*regexp.Regexp{regexp.MustCompile("t s")}},
+			responseEncoding: "",
+			padded:           false,
+			plaintext:        "",
+		}, {
+			testName:         "padding without padding encoder",
+			acceptEncoding:   "half",
+			markSensitive:    true,
+			mitigation:       &httpencoder.BreachMitigation{Pad: true, MaxPadding: 4},
+			responseEncoding: "",
+			padded:           false,
+			plaintext:        "",
+		}, {
+			testName:         "stacked encryption without padding skips compression",
+			acceptEncoding:   "half, aes128gcm",
+			markSensitive:    true,
+			mitigation:       &httpencoder.BreachMitigation{Pad: false, MaxPadding: 4},
+			responseEncoding: aes128gcm.Coding,
+			padded:           false,
+			plaintext:        testString,
+		}, {
+			testName:         "compressed body is padded by stacked encryption",
+			acceptEncoding:   "half, aes128gcm",
+			markSensitive:    true,
+			mitigation:       &httpencoder.BreachMitigation{Pad: true, MaxPadding: 4},
+			responseEncoding: "half, aes128gcm",
+			padded:           true,
+			plaintext:        halved.String(),
+		}, {
+			testName:         "encryption alone is padded",
+			acceptEncoding:   "aes128gcm",
+			markSensitive:    true,
+			mitigation:       &httpencoder.BreachMitigation{Pad: true, MaxPadding: 4},
+			responseEncoding: aes128gcm.Coding,
+			padded:           true,
+			plaintext:        testString,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			var paddedCalls atomic.Int32
+
+			opts := []httpencoder.Option{
+				httpencoder.WithStackedEncoder(aes128gcm.Coding, codec),
+				httpencoder.WithHooks(httpencoder.Hooks{
+					OnPadded: func(context.Context, string, int) {
+						paddedCalls.Add(1)
+					},
+				}),
+			}
+			if iterTest.mitigation != nil {
+				opts = append(opts, httpencoder.WithBreachMitigation(*iterTest.mitigation))
+			}
+
+			handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+				if iterTest.markSensitive && !httpencoder.MarkSensitive(responseWriter) {
+					t.Fatal("middleware's http.ResponseWriter not found")
+				}
+
+				_, _ = responseWriter.Write([]byte(testString))
+			})
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			httpencoder.New(encoders, nil, opts...)(handler).ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.responseEncoding {
+				t.Fatalf("invalid Content-Encoding header in response, want %s but got %s",
+					iterTest.responseEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			if (paddedCalls.Load() == 1) != iterTest.padded {
+				t.Fatalf("invalid OnPadded calls, want padded %v but got %d calls", iterTest.padded, paddedCalls.Load())
+			}
+
+			if iterTest.plaintext == "" {
+				return
+			}
+
+			decrypted := &bytes.Buffer{}
+
+			err := codec.Decode(context.Background(), decrypted, recorder.Body.Bytes())
+			if err != nil || decrypted.String() != iterTest.plaintext {
+				t.Fatalf("invalid decrypted response, want %s but got %s (%v)", iterTest.plaintext, decrypted, err)
+			}
+
+			unpadded := &bytes.Buffer{}
+			_ = codec.Encode(context.Background(), unpadded, []byte(iterTest.plaintext))
+
+			padding := recorder.Body.Len() - unpadded.Len()
+			if (iterTest.padded && (padding < 1 || padding > 4)) || (!iterTest.padded && padding != 0) {
+				t.Fatalf("invalid padding of response body, got %d bytes", padding)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package httpencoder
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+const identityEncoding = "identity"
+
+// WithEncodeConcurrency bounds number of simultaneous Encoder calls. When
+// limit is reached, response is sent as is instead of waiting, unless
+// degraded level is set with WithDegradedLevel. Hooks.OnDegraded reports
+// such responses.
+func WithEncodeConcurrency(limit int) Option {
+	return func(cfg *config) {
+		if limit > 0 {
+			cfg.encodeSemaphore = make(chan struct{}, limit)
+		}
+	}
+}
+
+// WithDegradedLevel makes middleware encode responses with provided cheap
+// level of LeveledEncoder instead of sending them as is, when limit set by
+// WithEncodeConcurrency is reached.
+func WithDegradedLevel(level int) Option {
+	return func(cfg *config) {
+		cfg.degradedLevel = level
+	}
+}
+
+func (cfg *config) acquireEncode() bool {
+	if cfg.encodeSemaphore == nil {
+		return true
+	}
+
+	select {
+	case cfg.encodeSemaphore <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cfg *config) releaseEncode() {
+	if cfg.encodeSemaphore != nil {
+		<-cfg.encodeSemaphore
+	}
+}
+
+func (cfg *config) degraded(request *http.Request, coding string) {
+	if cfg.hooks.OnDegraded != nil {
+		cfg.hooks.OnDegraded(request.Context(), coding)
+	}
+
+	cfg.log(request, cfg.logLevels.Degraded, "httpencoder: encode concurrency limit reached", slog.String("coding", coding))
+}
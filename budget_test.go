@@ -0,0 +1,219 @@
+package httpencoder_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type blocker struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (encoder blocker) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	close(encoder.started)
+	<-encoder.release
+
+	return leveler{}.Encode(ctx, to, from)
+}
+
+func (encoder blocker) EncodeLevel(ctx context.Context, to io.Writer, from []byte, level int) error {
+	return leveler{}.EncodeLevel(ctx, to, from, level)
+}
+
+func TestEncodeConcurrency(test *testing.T) {
+	test.Parallel()
+
+	tests := []struct {
+		testName        string
+		opts            []httpencoder.Option
+		contentEncoding string
+		responseBody    string
+	}{
+		{
+			testName:        "fallback to identity",
+			opts:            nil,
+			contentEncoding: "",
+			responseBody:    testString,
+		}, {
+			testName:        "fallback to degraded level",
+			opts:            []httpencoder.Option{httpencoder.WithDegradedLevel(1)},
+			contentEncoding: "block",
+			responseBody:    "1:" + testString,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			encoder := blocker{started: make(chan struct{}), release: make(chan struct{})}
+
+			var degraded string
+
+			opts := append([]httpencoder.Option{
+				httpencoder.WithEncodeConcurrency(1),
+				httpencoder.WithHooks(httpencoder.Hooks{
+					OnDegraded: func(_ context.Context, coding string) {
+						degraded = coding
+					},
+				}),
+			}, iterTest.opts...)
+
+			handler := httpencoder.New(map[string]httpencoder.Encoder{"block": encoder}, nil, opts...)(
+				http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+					_, _ = io.WriteString(responseWriter, testString)
+				}),
+			)
+
+			blocked := make(chan struct{})
+
+			go func() {
+				defer close(blocked)
+
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.Header.Set("Accept-Encoding", "block")
+				handler.ServeHTTP(httptest.NewRecorder(), request)
+			}()
+
+			<-encoder.started
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", "block")
+			handler.ServeHTTP(recorder, request)
+
+			close(encoder.release)
+			<-blocked
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.contentEncoding {
+				strFormat := "invalid Content-Encoding header in response, want %s but got %s"
+				t.Fatalf(strFormat, iterTest.contentEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			if recorder.Body.String() != iterTest.responseBody {
+				t.Fatalf("invalid response: want '%s' but got '%s'", iterTest.responseBody, recorder.Body)
+			}
+
+			expectedDegraded := iterTest.contentEncoding
+			if expectedDegraded == "" {
+				expectedDegraded = "identity"
+			}
+
+			if degraded != expectedDegraded {
+				t.Fatalf("invalid degraded coding, want %s but got %s", expectedDegraded, degraded)
+			}
+		})
+	}
+}
+
+func TestEnvelopeEncodeConcurrency(test *testing.T) {
+	test.Parallel()
+
+	encoder := blocker{started: make(chan struct{}), release: make(chan struct{})}
+
+	var degraded string
+
+	handler := httpencoder.New(map[string]httpencoder.Encoder{"block": encoder}, nil,
+		httpencoder.WithGRPC(),
+		httpencoder.WithEncodeConcurrency(1),
+		httpencoder.WithHooks(httpencoder.Hooks{
+			OnDegraded: func(_ context.Context, coding string) {
+				degraded = coding
+			},
+		}),
+	)(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Content-Type") != "application/grpc" {
+			_, _ = io.WriteString(responseWriter, testString)
+
+			return
+		}
+
+		responseWriter.Header().Set("Content-Type", "application/grpc")
+		_, _ = responseWriter.Write(envelopeFrame(0, []byte(testString)))
+	}))
+
+	blocked := make(chan struct{})
+
+	go func() {
+		defer close(blocked)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", "block")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}()
+
+	<-encoder.started
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/service/method", nil)
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("Grpc-Accept-Encoding", "block")
+	handler.ServeHTTP(recorder, request)
+
+	close(encoder.release)
+	<-blocked
+
+	if recorder.Body.String() != string(envelopeFrame(0, []byte(testString))) {
+		test.Fatalf("invalid response: want uncompressed message but got '%s'", recorder.Body)
+	}
+
+	if degraded != "identity" {
+		test.Fatalf("invalid degraded coding, want identity but got %s", degraded)
+	}
+}
+
+type sleeper struct{}
+
+func (sleeper) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return leveler{}.Encode(ctx, to, from)
+}
+
+func (sleeper) EncodeLevel(ctx context.Context, to io.Writer, from []byte, level int) error {
+	time.Sleep(20 * time.Millisecond)
+
+	return leveler{}.EncodeLevel(ctx, to, from, level)
+}
+
+func TestAdaptiveLevel(test *testing.T) {
+	test.Parallel()
+
+	handler := httpencoder.New(
+		map[string]httpencoder.Encoder{"sleep": sleeper{}, "level": leveler{}},
+		nil,
+		httpencoder.WithAdaptiveLevel(map[string]httpencoder.AdaptiveLevel{
+			"sleep": {
+				IdleLevel:   9,
+				BusyLevel:   1,
+				MaxLatency:  time.Millisecond,
+				MaxInFlight: 0,
+			},
+		}),
+	)(http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(responseWriter, testString)
+	}))
+
+	// coding without settings keeps encoder's default level while other one is busy
+	for _, iterTest := range []struct{ coding, expected string }{
+		{coding: "sleep", expected: "9:" + testString},
+		{coding: "sleep", expected: "1:" + testString},
+		{coding: "level", expected: "0:" + testString},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", iterTest.coding)
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Body.String() != iterTest.expected {
+			test.Fatalf("invalid response: want '%s' but got '%s'", iterTest.expected, recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type (
+	// gzipCodec implements "gzip" content coding with default level.
+	gzipCodec struct {
+		level int
+	}
+	// deflateCodec implements "deflate" content coding, which is zlib format
+	// of RFC 1950, with default level.
+	deflateCodec struct {
+		level int
+	}
+)
+
+func (codec gzipCodec) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return codec.EncodeLevel(ctx, to, from, codec.level)
+}
+
+func (gzipCodec) EncodeLevel(_ context.Context, to io.Writer, from []byte, level int) error {
+	if level == httpencoder.DefaultLevel {
+		level = gzip.DefaultCompression
+	}
+
+	gzipWriter, err := gzip.NewWriterLevel(to, level)
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+
+	_, err = gzipWriter.Write(from)
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+
+	return gzipWriter.Close() //nolint:wrapcheck // gzip errors are prefixed already
+}
+
+func (gzipCodec) Decode(_ context.Context, to io.Writer, from []byte) error {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(from))
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+
+	_, err = io.Copy(to, gzipReader) //nolint:gosec // decoded size is limited by policy
+	if err != nil {
+		return fmt.Errorf("gzip: %w", err)
+	}
+
+	return nil
+}
+
+func (codec deflateCodec) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return codec.EncodeLevel(ctx, to, from, codec.level)
+}
+
+func (deflateCodec) EncodeLevel(_ context.Context, to io.Writer, from []byte, level int) error {
+	if level == httpencoder.DefaultLevel {
+		level = zlib.DefaultCompression
+	}
+
+	zlibWriter, err := zlib.NewWriterLevel(to, level)
+	if err != nil {
+		return fmt.Errorf("deflate: %w", err)
+	}
+
+	_, err = zlibWriter.Write(from)
+	if err != nil {
+		return fmt.Errorf("deflate: %w", err)
+	}
+
+	return zlibWriter.Close() //nolint:wrapcheck // deflate errors are prefixed already
+}
+
+func (deflateCodec) Decode(_ context.Context, to io.Writer, from []byte) error {
+	zlibReader, err := zlib.NewReader(bytes.NewReader(from))
+	if err != nil {
+		return fmt.Errorf("deflate: %w", err)
+	}
+	defer zlibReader.Close()
+
+	_, err = io.Copy(to, zlibReader) //nolint:gosec // decoded size is limited by policy
+	if err != nil {
+		return fmt.Errorf("deflate: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+// Command httpencoder-proxy is reverse proxy, which decodes requests and
+// encodes responses of upstream service with httpencoder middleware.
+//
+// Usage:
+//
+//	httpencoder-proxy -upstream http://127.0.0.1:8080 [flags]
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type options struct {
+	listen          string
+	upstream        string
+	codecs          string
+	mediaTypes      string
+	level           int
+	minSize         int
+	minSavings      float64
+	maxRequestBody  int64
+	maxDecodedSize  int64
+	concurrency     int
+	passRequestBody bool
+}
+
+const (
+	readHeaderTimeout = 10 * time.Second
+	// exitUsage is exit code of flag package for invalid usage.
+	exitUsage = 2
+)
+
+var (
+	errUnknownCodec = errors.New("unknown codec")
+	errInvalidLevel = errors.New("invalid compression level")
+	errNoUpstream   = errors.New("upstream is required")
+)
+
+func main() {
+	opts, err := parseFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+
+	if err != nil {
+		os.Exit(exitUsage)
+	}
+
+	handler, err := newHandler(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(exitUsage)
+	}
+
+	server := &http.Server{
+		Addr:              opts.listen,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	slog.Info("httpencoder-proxy: listening", slog.String("addr", opts.listen), slog.String("upstream", opts.upstream))
+
+	err = server.ListenAndServe()
+	if err != nil {
+		slog.Error("httpencoder-proxy: server failed", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+}
+
+func parseFlags(args []string, output io.Writer) (options, error) {
+	var opts options
+
+	flags := flag.NewFlagSet("httpencoder-proxy", flag.ContinueOnError)
+	flags.SetOutput(output)
+
+	flags.StringVar(&opts.listen, "listen", ":8000", "address to listen on")
+	flags.StringVar(&opts.upstream, "upstream", "", "upstream URL, required")
+	flags.StringVar(&opts.codecs, "codecs", "gzip,deflate", "comma separated codecs in server preference order: gzip, deflate")
+	flags.StringVar(&opts.mediaTypes, "mime", "",
+		`comma separated media type patterns of responses to encode, like "text/*,application/json", all by default`)
+	flags.IntVar(&opts.level, "level", httpencoder.DefaultLevel, "compression level, codec default by default")
+	flags.IntVar(&opts.minSize, "min-size", 0, "minimal response size in bytes to encode")
+	flags.Float64Var(&opts.minSavings, "min-savings", 0, "minimal fraction of saved bytes to send encoded response")
+	flags.Int64Var(&opts.maxRequestBody, "max-request-body", 0, "limit of request body size in bytes, 0 means no limit")
+	flags.Int64Var(&opts.maxDecodedSize, "max-decoded-size", 0, "limit of decoded request body size in bytes, 0 means no limit")
+	flags.IntVar(&opts.concurrency, "concurrency", 0, "limit of simultaneous encodes, 0 means no limit")
+	flags.BoolVar(&opts.passRequestBody, "pass-request-body", false, "forward encoded request bodies without decoding")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return opts, fmt.Errorf("httpencoder-proxy: %w", err)
+	}
+
+	if opts.upstream == "" {
+		flags.Usage()
+
+		return opts, fmt.Errorf("httpencoder-proxy: %w", errNoUpstream)
+	}
+
+	return opts, nil
+}
+
+func newHandler(opts options) (http.Handler, error) {
+	target, err := url.Parse(opts.upstream)
+	if err != nil {
+		return nil, fmt.Errorf("httpencoder-proxy: invalid upstream: %w", err)
+	}
+
+	// gzip and zlib share levels, so invalid one would fail every encode after WriteHeader
+	if opts.level != httpencoder.DefaultLevel {
+		if _, err = gzip.NewWriterLevel(io.Discard, opts.level); err != nil {
+			return nil, fmt.Errorf("httpencoder-proxy: %w: %d", errInvalidLevel, opts.level)
+		}
+	}
+
+	var (
+		encoders   = map[string]httpencoder.Encoder{}
+		decoders   = map[string]httpencoder.Decoder{}
+		preference []string
+	)
+
+	for _, name := range strings.Split(opts.codecs, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case "gzip":
+			encoders[name], decoders[name] = gzipCodec{level: opts.level}, gzipCodec{level: opts.level}
+		case "deflate":
+			encoders[name], decoders[name] = deflateCodec{level: opts.level}, deflateCodec{level: opts.level}
+		case "":
+			continue
+		default:
+			return nil, fmt.Errorf("httpencoder-proxy: %w: %s", errUnknownCodec, name)
+		}
+
+		preference = append(preference, name)
+	}
+
+	policy := httpencoder.Policy{
+		MinSize:            opts.minSize,
+		MaxRequestBodySize: opts.maxRequestBody,
+		MaxDecodedSize:     opts.maxDecodedSize,
+		DisableDecode:      opts.passRequestBody,
+	}
+
+	middlewareOptions := []httpencoder.Option{
+		httpencoder.WithTranscoding(true),
+		httpencoder.WithPolicy(func(*http.Request) httpencoder.Policy { return policy }),
+		httpencoder.WithEncodeConcurrency(opts.concurrency),
+	}
+
+	if opts.minSavings > 0 {
+		middlewareOptions = append(middlewareOptions, httpencoder.WithMinSavings(opts.minSavings))
+	}
+
+	mediaTypes := opts.mediaTypes
+	if mediaTypes == "" {
+		mediaTypes = "*/*"
+	}
+
+	for _, pattern := range strings.Split(mediaTypes, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			middlewareOptions = append(middlewareOptions, httpencoder.WithMediaTypeEncoders(pattern, encoders, preference...))
+		}
+	}
+
+	// encoders are registered per media type only, so other responses are sent as is
+	middleware := httpencoder.New(nil, decoders, middlewareOptions...)
+
+	return middleware(httpencoder.NewReverseProxy(target)), nil
+}
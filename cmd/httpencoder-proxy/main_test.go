@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProxy(test *testing.T) {
+	test.Parallel()
+
+	payload := strings.Repeat(`{"key":"value"}`, 100)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+
+		responseWriter.Header().Set("Content-Type", request.URL.Query().Get("type"))
+		responseWriter.Header().Set("X-Request-Encoding", request.Header.Get("Content-Encoding"))
+
+		_, _ = responseWriter.Write(body)
+	}))
+	test.Cleanup(upstream.Close)
+
+	opts, err := parseFlags([]string{"-upstream", upstream.URL, "-codecs", "gzip, deflate", "-mime", "application/json"}, io.Discard)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	handler, err := newHandler(opts)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	gzipped := &bytes.Buffer{}
+	_ = gzipCodec{level: opts.level}.Encode(context.Background(), gzipped, []byte(payload))
+
+	tests := []struct {
+		testName         string
+		contentType      string
+		acceptEncoding   string
+		requestEncoding  string
+		requestBody      []byte
+		responseEncoding string
+	}{
+		{
+			testName:         "matched media type is encoded",
+			contentType:      "application/json",
+			acceptEncoding:   "deflate, gzip",
+			requestEncoding:  "",
+			requestBody:      []byte(payload),
+			responseEncoding: "gzip",
+		}, {
+			testName:         "not matched media type is sent as is",
+			contentType:      "image/png",
+			acceptEncoding:   "gzip",
+			requestEncoding:  "",
+			requestBody:      []byte(payload),
+			responseEncoding: "",
+		}, {
+			testName:         "request body is decoded",
+			contentType:      "application/json",
+			acceptEncoding:   "",
+			requestEncoding:  "gzip",
+			requestBody:      gzipped.Bytes(),
+			responseEncoding: "",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/?type="+iterTest.contentType, bytes.NewReader(iterTest.requestBody))
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+			request.Header.Set("Content-Encoding", iterTest.requestEncoding)
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("X-Request-Encoding") != "" {
+				t.Fatalf("request body is not decoded: %s", recorder.Header().Get("X-Request-Encoding"))
+			}
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.responseEncoding {
+				t.Fatalf("invalid Content-Encoding header in response, want %s but got %s",
+					iterTest.responseEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			body := recorder.Body.Bytes()
+
+			if iterTest.responseEncoding == "gzip" {
+				gzipReader, err := gzip.NewReader(recorder.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				body, _ = io.ReadAll(gzipReader)
+			}
+
+			if string(body) != payload {
+				t.Fatalf("invalid response body: %s", body)
+			}
+		})
+	}
+}
+
+func TestInvalidConfiguration(test *testing.T) {
+	test.Parallel()
+
+	_, err := parseFlags(nil, io.Discard)
+	if !errors.Is(err, errNoUpstream) {
+		test.Fatalf("invalid error without upstream, want %v but got %v", errNoUpstream, err)
+	}
+
+	_, err = newHandler(options{upstream: "http://localhost", codecs: "gzip,zstd"})
+	if !errors.Is(err, errUnknownCodec) {
+		test.Fatalf("invalid error with unknown codec, want %v but got %v", errUnknownCodec, err)
+	}
+
+	_, err = newHandler(options{upstream: "http://localhost", codecs: "gzip", level: 42})
+	if !errors.Is(err, errInvalidLevel) {
+		test.Fatalf("invalid error with invalid level, want %v but got %v", errInvalidLevel, err)
+	}
+}
@@ -0,0 +1,44 @@
+package httpencoder
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	responseEncodingKey contextKey = iota
+	decodedEncodingsKey
+	policyKey
+)
+
+// ResponseEncoding returns content coding negotiated by middleware for
+// response of request with provided context before handler call, or empty
+// string if nothing was negotiated. Response can still be sent with other
+// encoding, e.g. as is if it turns out to be too small, see Hooks.OnEncoded
+// and Hooks.OnSkipped for final decision.
+func ResponseEncoding(ctx context.Context) string {
+	encodingType, _ := ctx.Value(responseEncodingKey).(string)
+
+	return encodingType
+}
+
+// DecodedEncodings returns content codings removed by middleware from
+// body of request with provided context in the order they were applied.
+func DecodedEncodings(ctx context.Context) []string {
+	codings, okay := ctx.Value(decodedEncodingsKey).([]string)
+	if !okay {
+		return nil
+	}
+
+	return codings
+}
+
+func withResponseEncoding(request *http.Request, encodingType string) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), responseEncodingKey, encodingType))
+}
+
+func withDecodedEncodings(request *http.Request, codings []string) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), decodedEncodingsKey, codings))
+}
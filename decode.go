@@ -1,62 +1,164 @@
 package httpencoder
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
-	"sync"
+	"strings"
+	"time"
 )
 
-func decode(bufferPool *sync.Pool, decoders map[string]Decoder, next http.Handler) http.Handler {
+func decode(cfg *config, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		request, policy := cfg.resolvePolicy(request)
+
+		if policy.MaxRequestBodySize > 0 && request.Body != nil {
+			request.Body = http.MaxBytesReader(responseWriter, request.Body, policy.MaxRequestBodySize)
+		}
+
 		header := compactAndLow([]byte(request.Header.Get("Content-Encoding")))
-		if len(header) == 0 {
+		if (len(header) == 0 && !cfg.verifiesDigests(request)) || policy.DisableDecode || cfg.proxy ||
+			(cfg.requestNoTransform && hasCacheDirective(request.Header, "no-transform")) {
+			switch {
+			case len(header) == 0:
+			case policy.DisableDecode || cfg.proxy:
+				cfg.skipped(request, SkipDisabled)
+			default:
+				cfg.skipped(request, SkipNoTransform)
+			}
+
 			next.ServeHTTP(responseWriter, request)
 
 			return
 		}
 
-		bodyBuffer := bufferGet(bufferPool)
-		defer bufferPut(bufferPool, bodyBuffer)
+		decoders := cfg.decoders
+		if policy.Decoders != nil {
+			decoders = policy.Decoders
+		}
+
+		bodyBuffer := bufferGet(cfg.bufferPool)
+		defer bufferPut(cfg.bufferPool, bodyBuffer)
 
 		_, err := bodyBuffer.ReadFrom(request.Body)
 		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				cfg.limited(request, "", maxBytesError.Limit)
+
+				http.Error(responseWriter, ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+
+				return
+			}
+
 			http.Error(responseWriter, "failed to read http request body", http.StatusBadRequest)
 
 			return
 		}
 
-		for iter := 0; iter < len(header); iter++ {
-			start := iter
+		// both digests describe representation with its content coding
+		for _, field := range [...]string{"Content-Digest", "Repr-Digest"} {
+			if len(cfg.digests) > 0 && !verifyDigest(request.Header.Get(field), bodyBuffer.Bytes()) {
+				cfg.mismatched(request, field)
+
+				http.Error(responseWriter, ErrDigestMismatch.Error(), http.StatusBadRequest)
 
-			for iter < len(header) && isAlpha(header[iter]) {
-				iter++
+				return
 			}
+		}
 
-			decoder, exist := decoders[string(header[start:iter])]
+		decodedBuffer := bufferGet(cfg.bufferPool)
+		defer bufferPut(cfg.bufferPool, decodedBuffer)
+
+		var (
+			codings          = parseContentEncoding(header)
+			decodedEncodings []string
+			decodeDuration   time.Duration
+		)
+
+		// codings are listed in the order they were applied, so undo them from the last one
+		for last := len(codings) - 1; last >= 0; last-- {
+			coding := codings[last]
+
+			decoder, exist := decoders[coding]
 			if !exist {
-				// not found decoder, pass it down without decoding
-				request.Body = io.NopCloser(bodyBuffer)
-				request.Header.Set("Content-Encoding", string(header[start:]))
+				// not found decoder, pass it down with the rest of codings
+				cfg.skipped(request, SkipUnknownEncoding, slog.String("coding", coding))
+
+				request = withDecodedEncodings(request, decodedEncodings)
+				cfg.addDecodeTiming(responseWriter, decodedEncodings, decodeDuration)
+				setBody(request, bodyBuffer)
+				request.Header.Set("Content-Encoding", strings.Join(codings[:last+1], ", "))
+
+				if len(decodedEncodings) > 0 {
+					withoutDigests(request.Header)
+				}
 
 				next.ServeHTTP(responseWriter, request)
 
 				return
 			}
 
-			content := bodyBuffer.Bytes()
-			bodyBuffer.Reset()
+			decodedBuffer.Reset()
+
+			decodeStart := time.Now()
+
+			var decodedBody io.Writer = decodedBuffer
+			if policy.MaxDecodedSize > 0 {
+				decodedBody = &limitedWriter{buffer: decodedBuffer, remaining: policy.MaxDecodedSize}
+			}
+
+			err := decoder.Decode(request.Context(), decodedBody, bodyBuffer.Bytes())
+			if errors.Is(err, ErrTooLarge) {
+				cfg.limited(request, coding, policy.MaxDecodedSize)
+
+				http.Error(responseWriter, ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+
+				return
+			}
 
-			err := decoder.Decode(request.Context(), bodyBuffer, content)
 			if err != nil {
+				cfg.failed(request, coding, bodyBuffer.Len(), err)
+
 				http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
 
 				return
 			}
+
+			duration := time.Since(decodeStart)
+			decodeDuration += duration
+
+			cfg.decoded(request.Context(), coding, bodyBuffer.Len(), decodedBuffer.Len(), duration)
+
+			bodyBuffer, decodedBuffer = decodedBuffer, bodyBuffer
+			decodedEncodings = codings[last:]
 		}
 
-		request.Body = io.NopCloser(bodyBuffer)
+		request = withDecodedEncodings(request, decodedEncodings)
+		cfg.addDecodeTiming(responseWriter, decodedEncodings, decodeDuration)
+		setBody(request, bodyBuffer)
 		request.Header.Del("Content-Encoding")
 
+		if len(decodedEncodings) > 0 {
+			withoutDigests(request.Header)
+		}
+
 		next.ServeHTTP(responseWriter, request)
 	})
 }
+
+// setBody replaces request body with decoded one, so its length
+// matches for handlers which forward request, like httputil.ReverseProxy.
+func setBody(request *http.Request, body *bytes.Buffer) {
+	request.Body = io.NopCloser(body)
+	request.ContentLength = int64(body.Len())
+	request.Header.Del("Content-Length")
+}
+
+func (cfg *config) addDecodeTiming(responseWriter http.ResponseWriter, codings []string, duration time.Duration) {
+	if cfg.serverTiming && len(codings) > 0 {
+		responseWriter.Header().Add("Server-Timing", serverTiming("dec", strings.Join(codings, ", "), duration))
+	}
+}
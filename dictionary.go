@@ -0,0 +1,253 @@
+package httpencoder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	// DictionaryEncoder implements Encoder with shared dictionary for
+	// Compression Dictionary Transport (RFC 9842), like brotli for "dcb" or
+	// zstandard for "dcz" codings. It writes raw compressed stream only,
+	// middleware writes "dcb" and "dcz" stream headers itself.
+	DictionaryEncoder interface {
+		Encoder
+		// EncodeDictionary encodes http.ResponseWriter body with shared dictionary.
+		EncodeDictionary(ctx context.Context, to io.Writer, from, dictionary []byte) error
+	}
+
+	// DictionaryStore keeps dictionaries by their SHA-256 hash. Responses
+	// with Use-As-Dictionary header are added to store by middleware.
+	DictionaryStore struct {
+		dictionaries map[[sha256.Size]byte][]byte
+		order        [][sha256.Size]byte
+		maxEntries   int
+		maxSize      int
+		size         int
+		mutex        sync.RWMutex
+	}
+
+	// dictionaryEncoder adapts DictionaryEncoder with matched dictionary to Encoder.
+	dictionaryEncoder struct {
+		encoder    DictionaryEncoder
+		dictionary []byte
+		header     []byte
+	}
+)
+
+// Default limits of DictionaryStore used when NewDictionaryStore limits are zero.
+const (
+	DefaultDictionaryEntries = 16
+	DefaultDictionarySize    = 8 << 20
+)
+
+// NewDictionaryStore returns empty DictionaryStore, which keeps the most
+// recently added dictionaries up to maxEntries of them and up to maxSize
+// bytes in total, or DefaultDictionaryEntries and DefaultDictionarySize
+// for zero limits.
+func NewDictionaryStore(maxEntries, maxSize int) *DictionaryStore {
+	if maxEntries <= 0 {
+		maxEntries = DefaultDictionaryEntries
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultDictionarySize
+	}
+
+	return &DictionaryStore{
+		dictionaries: map[[sha256.Size]byte][]byte{},
+		order:        nil,
+		maxEntries:   maxEntries,
+		maxSize:      maxSize,
+		size:         0,
+		mutex:        sync.RWMutex{},
+	}
+}
+
+// WithDictionaries enables Compression Dictionary Transport: responses with
+// Use-As-Dictionary header are added to store, and DictionaryEncoder codings
+// are negotiated only if Available-Dictionary request header matches stored
+// dictionary. Responses get Vary: Accept-Encoding, Available-Dictionary.
+func WithDictionaries(store *DictionaryStore) Option {
+	return func(cfg *config) {
+		cfg.dictionaries = store
+	}
+}
+
+// Add stores dictionary and returns its Available-Dictionary header value.
+// Dictionary larger than store size limit is not stored.
+func (store *DictionaryStore) Add(dictionary []byte) string {
+	hash := sha256.Sum256(dictionary)
+	value := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+
+	if len(dictionary) > store.maxSize {
+		return value
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, exist := store.dictionaries[hash]; exist {
+		return value
+	}
+
+	store.dictionaries[hash] = append([]byte(nil), dictionary...)
+	store.order = append(store.order, hash)
+	store.size += len(dictionary)
+
+	for len(store.order) > store.maxEntries || store.size > store.maxSize {
+		store.size -= len(store.dictionaries[store.order[0]])
+		delete(store.dictionaries, store.order[0])
+		store.order = store.order[1:]
+	}
+
+	return value
+}
+
+// Get returns dictionary by Available-Dictionary header value.
+func (store *DictionaryStore) Get(availableDictionary string) ([]byte, bool) {
+	hash, okay := parseAvailableDictionary(availableDictionary)
+	if !okay {
+		return nil, false
+	}
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	dictionary, exist := store.dictionaries[hash]
+
+	return dictionary, exist
+}
+
+//nolint:wrapcheck // there is simple framing wrapper, no need to wrap
+func (encoder *dictionaryEncoder) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	_, err := to.Write(encoder.header)
+	if err != nil {
+		return err
+	}
+
+	return encoder.encoder.EncodeDictionary(ctx, to, from, encoder.dictionary)
+}
+
+// parseAvailableDictionary parses sf-binary SHA-256 hash like ":base64:".
+func parseAvailableDictionary(value string) ([sha256.Size]byte, bool) {
+	var hash [sha256.Size]byte
+
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+		return hash, false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+	if err != nil || len(decoded) != sha256.Size {
+		return hash, false
+	}
+
+	copy(hash[:], decoded)
+
+	return hash, true
+}
+
+// dictionaryStreamHeader returns stream header of dictionary compressed
+// body: magic number of coding followed by SHA-256 hash of dictionary.
+func dictionaryStreamHeader(coding string, dictionary []byte) []byte {
+	var magic []byte
+
+	switch coding {
+	case "dcb":
+		magic = []byte{0xff, 0x44, 0x43, 0x42}
+	case "dcz":
+		magic = []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+	default:
+		return nil
+	}
+
+	hash := sha256.Sum256(dictionary)
+
+	return append(magic, hash[:]...)
+}
+
+// withDictionary returns Encoder for negotiated coding with matched
+// dictionary, or the same Encoder if it is not a DictionaryEncoder.
+//
+//nolint:ireturn // helper function
+func withDictionary(encoder Encoder, coding string, dictionary []byte) Encoder {
+	dictEncoder, okay := encoder.(DictionaryEncoder)
+	if !okay || dictionary == nil {
+		return encoder
+	}
+
+	return &dictionaryEncoder{
+		encoder:    dictEncoder,
+		dictionary: dictionary,
+		header:     dictionaryStreamHeader(coding, dictionary),
+	}
+}
+
+// matchDictionary returns dictionary of request, and Accept-Encoding header
+// without DictionaryEncoder codings if there is no matched dictionary.
+func (cfg *config) matchDictionary(
+	request *http.Request, acceptEncodingHeader []byte, encoders map[string]Encoder,
+) ([]byte, []byte) {
+	if availableDictionary := request.Header.Get("Available-Dictionary"); availableDictionary != "" {
+		if dictionary, exist := cfg.dictionaries.Get(availableDictionary); exist {
+			return dictionary, acceptEncodingHeader
+		}
+	}
+
+	var (
+		filtered     []byte
+		encodingType string
+		start        int
+	)
+
+	for pos := 0; pos < len(acceptEncodingHeader); pos++ {
+		start = pos
+		encodingType, pos = getNextAcceptEncodingType(acceptEncodingHeader, pos)
+		_, pos = getNextQualityValue(acceptEncodingHeader, pos)
+
+		if _, isDictionaryEncoder := encoders[encodingType].(DictionaryEncoder); isDictionaryEncoder {
+			continue
+		}
+
+		end := pos
+		if end > len(acceptEncodingHeader) {
+			end = len(acceptEncodingHeader)
+		}
+
+		filtered = append(filtered, acceptEncodingHeader[start:end]...)
+		filtered = append(filtered, ',')
+	}
+
+	return nil, filtered
+}
+
+// storeDictionary adds Vary of dictionary negotiation to response and stores
+// its body, if it is 200 response with Use-As-Dictionary header not encoded
+// by handler, whether response is going to be encoded or not.
+func (cfg *config) storeDictionary(header http.Header, statusCode int, body []byte) {
+	if cfg.dictionaries == nil {
+		return
+	}
+
+	addVary(header, "Accept-Encoding", "Available-Dictionary")
+
+	if statusCode == http.StatusOK && header.Get("Use-As-Dictionary") != "" && header.Get("Content-Encoding") == "" {
+		cfg.dictionaries.Add(body)
+	}
+}
+
+func addVary(header http.Header, names ...string) {
+	present := strings.ToLower(strings.Join(header.Values("Vary"), ","))
+
+	for _, name := range names {
+		if !strings.Contains(present, strings.ToLower(name)) {
+			header.Add("Vary", name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package httpencoder_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type dictionaryPrefixer struct{}
+
+func (dictionaryPrefixer) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return dictionaryPrefixer{}.EncodeDictionary(ctx, to, from, nil)
+}
+
+func (dictionaryPrefixer) EncodeDictionary(_ context.Context, to io.Writer, from, dictionary []byte) error {
+	_, err := to.Write(append(append([]byte{}, dictionary...), from...))
+
+	return err
+}
+
+func TestDictionaries(test *testing.T) {
+	test.Parallel()
+
+	const dictionaryBody = "app v1"
+
+	hash := sha256.Sum256([]byte(dictionaryBody))
+	availableDictionary := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+
+	store := httpencoder.NewDictionaryStore(1, 0)
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}, "dcb": dictionaryPrefixer{}}
+	compress := httpencoder.New(encoders, nil, httpencoder.WithDictionaries(store))
+
+	handler := compress(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/app.v1.js" {
+			responseWriter.Header().Set("Use-As-Dictionary", `match="/app.*.js"`)
+			_, _ = io.WriteString(responseWriter, dictionaryBody)
+
+			return
+		}
+
+		_, _ = io.WriteString(responseWriter, "app v2")
+	}))
+
+	serve := func(target, dictionary string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("Accept-Encoding", "dcb, repeate;q=0.5")
+
+		if dictionary != "" {
+			request.Header.Set("Available-Dictionary", dictionary)
+		}
+
+		handler.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	if recorder := serve("/app.v1.js", ""); recorder.Header().Get("Content-Encoding") != "repeate" {
+		test.Fatalf("dcb must not be negotiated without dictionary, got %s", recorder.Header().Get("Content-Encoding"))
+	}
+
+	if _, exist := store.Get(availableDictionary); !exist {
+		test.Fatal("response with Use-As-Dictionary header must be stored")
+	}
+
+	recorder := serve("/app.v2.js", availableDictionary)
+	if recorder.Header().Get("Content-Encoding") != "dcb" {
+		test.Fatalf("dcb must be negotiated with matched dictionary, got %s", recorder.Header().Get("Content-Encoding"))
+	}
+
+	expected := append(append([]byte{0xff, 0x44, 0x43, 0x42}, hash[:]...), dictionaryBody+"app v2"...)
+	if !bytes.Equal(recorder.Body.Bytes(), expected) {
+		test.Fatalf("invalid dcb response: want '%v' but got '%v'", expected, recorder.Body.Bytes())
+	}
+
+	if vary := recorder.Header().Values("Vary"); len(vary) != 2 || vary[1] != "Available-Dictionary" {
+		test.Fatalf("invalid Vary header: %v", vary)
+	}
+
+	if recorder := serve("/app.v2.js", ":AAAA:"); recorder.Header().Get("Content-Encoding") != "repeate" {
+		test.Fatalf("dcb must not be negotiated with unknown dictionary, got %s", recorder.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestDictionaryStoreLimits(test *testing.T) {
+	test.Parallel()
+
+	store := httpencoder.NewDictionaryStore(0, 8)
+
+	first := store.Add([]byte("dict 1"))
+	tooLarge := store.Add([]byte("too large dictionary"))
+	second := store.Add([]byte("dict 2"))
+
+	if _, exist := store.Get(tooLarge); exist {
+		test.Fatal("dictionary larger than size limit must not be stored")
+	}
+
+	if _, exist := store.Get(first); exist {
+		test.Fatal("oldest dictionary must be evicted by size limit")
+	}
+
+	if dictionary, exist := store.Get(second); !exist || string(dictionary) != "dict 2" {
+		test.Fatalf("invalid stored dictionary, want dict 2 but got %s", dictionary)
+	}
+}
+
+func TestDictionaryOfIdentityResponse(test *testing.T) {
+	test.Parallel()
+
+	for _, acceptEncoding := range []string{"", "fake"} {
+		store := httpencoder.NewDictionaryStore(0, 0)
+		encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+
+		handler := httpencoder.New(encoders, nil, httpencoder.WithDictionaries(store))(
+			http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Use-As-Dictionary", `match="/app.*.js"`)
+				_, _ = io.WriteString(responseWriter, "app v1")
+			}),
+		)
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/app.v1.js", nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+
+		handler.ServeHTTP(recorder, request)
+
+		hash := sha256.Sum256([]byte("app v1"))
+		if _, exist := store.Get(":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"); !exist {
+			test.Fatalf("identity response with Use-As-Dictionary header must be stored, Accept-Encoding: %s", acceptEncoding)
+		}
+
+		if vary := recorder.Header().Values("Vary"); len(vary) != 2 || vary[1] != "Available-Dictionary" {
+			test.Fatalf("invalid Vary header: %v", vary)
+		}
+
+		if recorder.Body.String() != "app v1" {
+			test.Fatalf("invalid response body, want app v1 but got %s", recorder.Body)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package httpencoder
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Digest algorithms of RFC 9530 supported by WithDigests.
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+)
+
+// ErrDigestMismatch is returned when request body does not match
+// its Content-Digest or Repr-Digest header.
+var ErrDigestMismatch = errors.New("httpencoder: digest mismatch")
+
+// WithDigests makes middleware add RFC 9530 Content-Digest and Repr-Digest
+// to responses with provided algorithms, DigestSHA256 or DigestSHA512, and
+// verify such headers of requests with any supported algorithm. As content
+// coding is part of representation by RFC 9110, both digests are computed
+// over body as it is sent, whoever encoded it, so they are equal for full
+// responses, and both are verified before decoding requests. Requests with
+// mismatched digest are rejected with 400 status. Responses are buffered to
+// compute digests, even if they are not encoded.
+func WithDigests(algorithms ...string) Option {
+	return func(cfg *config) {
+		for _, algorithm := range algorithms {
+			algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+			if newDigestHash(algorithm) != nil {
+				cfg.digests = append(cfg.digests, algorithm)
+			}
+		}
+	}
+}
+
+func newDigestHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case DigestSHA256:
+		return sha256.New()
+	case DigestSHA512:
+		return sha512.New()
+	default:
+		return nil
+	}
+}
+
+// digestField formats body digests as RFC 8941 dictionary,
+// e.g. "sha-256=:base64:".
+func digestField(algorithms []string, body []byte) string {
+	var builder strings.Builder
+
+	for _, algorithm := range algorithms {
+		digest := newDigestHash(algorithm)
+		_, _ = digest.Write(body)
+
+		if builder.Len() > 0 {
+			builder.WriteString(", ")
+		}
+
+		builder.WriteString(algorithm + "=:" + base64.StdEncoding.EncodeToString(digest.Sum(nil)) + ":")
+	}
+
+	return builder.String()
+}
+
+// setDigests adds Content-Digest and Repr-Digest of non empty response body,
+// which is complete representation data with its content coding.
+func (cfg *config) setDigests(header http.Header, sent []byte) {
+	if len(cfg.digests) == 0 || len(sent) == 0 {
+		return
+	}
+
+	field := digestField(cfg.digests, sent)
+
+	header.Set("Content-Digest", field)
+	header.Set("Repr-Digest", field)
+}
+
+// verifyDigest reports whether body matches every supported
+// algorithm of digest header field. Unknown algorithms are ignored.
+func verifyDigest(field string, body []byte) bool {
+	for _, member := range strings.Split(field, ",") {
+		algorithm, value, found := strings.Cut(strings.TrimSpace(member), "=")
+		if !found {
+			continue
+		}
+
+		digest := newDigestHash(strings.ToLower(strings.TrimSpace(algorithm)))
+		if digest == nil {
+			continue
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return false
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+		if err != nil {
+			return false
+		}
+
+		_, _ = digest.Write(body)
+
+		if subtle.ConstantTimeCompare(digest.Sum(nil), expected) != 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withoutDigests removes digests of encoded request body, which are not valid
+// for decoded one anymore.
+func withoutDigests(header http.Header) {
+	header.Del("Content-Digest")
+	header.Del("Repr-Digest")
+}
+
+// verifiesDigests reports whether request body has to be read to verify digests.
+func (cfg *config) verifiesDigests(request *http.Request) bool {
+	return len(cfg.digests) > 0 &&
+		(request.Header.Get("Content-Digest") != "" || request.Header.Get("Repr-Digest") != "")
+}
+
+func (cfg *config) mismatched(request *http.Request, field string) {
+	if cfg.hooks.OnError != nil {
+		cfg.hooks.OnError(request.Context(), "", ErrDigestMismatch)
+	}
+
+	cfg.log(request, cfg.logLevels.Failure, "httpencoder: digest mismatch",
+		slog.String("field", field),
+		slog.Int("status", http.StatusBadRequest),
+	)
+}
@@ -0,0 +1,175 @@
+package httpencoder_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func sha256Field(body string) string {
+	digest := sha256.Sum256([]byte(body))
+
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+}
+
+func TestDigests(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	compress := httpencoder.New(encoders, decoders, httpencoder.WithDigests(httpencoder.DigestSHA256))
+
+	reversed := string(reverse([]byte(testString)))
+	repeated := "tteesstt  ssttrriinngg"
+
+	tests := []struct {
+		testName              string
+		acceptEncoding        string
+		requestEncoding       string
+		requestBody           string
+		requestContentDigest  string
+		requestReprDigest     string
+		responseStatusCode    int
+		responseContentDigest string
+		responseReprDigest    string
+	}{
+		{
+			testName:              "identity response",
+			acceptEncoding:        "",
+			requestEncoding:       "",
+			requestBody:           testString,
+			requestContentDigest:  "",
+			requestReprDigest:     "",
+			responseStatusCode:    returnedStatusCode,
+			responseContentDigest: sha256Field(reversed),
+			responseReprDigest:    sha256Field(reversed),
+		}, {
+			testName:              "encoded response",
+			acceptEncoding:        "repeate",
+			requestEncoding:       "",
+			requestBody:           testString,
+			requestContentDigest:  "",
+			requestReprDigest:     "",
+			responseStatusCode:    returnedStatusCode,
+			responseContentDigest: sha256Field(string(reverse([]byte(repeated)))),
+			responseReprDigest:    sha256Field(string(reverse([]byte(repeated)))),
+		}, {
+			testName:              "valid request digests",
+			acceptEncoding:        "",
+			requestEncoding:       "repeate",
+			requestBody:           repeated,
+			requestContentDigest:  sha256Field(repeated) + ", md5=:AAAA:",
+			requestReprDigest:     sha256Field(repeated),
+			responseStatusCode:    returnedStatusCode,
+			responseContentDigest: sha256Field(reversed),
+			responseReprDigest:    sha256Field(reversed),
+		}, {
+			testName:              "invalid content digest",
+			acceptEncoding:        "",
+			requestEncoding:       "repeate",
+			requestBody:           repeated,
+			requestContentDigest:  sha256Field(testString),
+			requestReprDigest:     "",
+			responseStatusCode:    http.StatusBadRequest,
+			responseContentDigest: "",
+			responseReprDigest:    "",
+		}, {
+			testName:              "invalid repr digest",
+			acceptEncoding:        "",
+			requestEncoding:       "repeate",
+			requestBody:           repeated,
+			requestContentDigest:  "",
+			requestReprDigest:     sha256Field(testString),
+			responseStatusCode:    http.StatusBadRequest,
+			responseContentDigest: "",
+			responseReprDigest:    "",
+		}, {
+			testName:              "invalid digest of identity request",
+			acceptEncoding:        "",
+			requestEncoding:       "",
+			requestBody:           testString,
+			requestContentDigest:  "",
+			requestReprDigest:     "sha-256=:AAAA:",
+			responseStatusCode:    http.StatusBadRequest,
+			responseContentDigest: "",
+			responseReprDigest:    "",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(iterTest.requestBody))
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+			request.Header.Set("Content-Encoding", iterTest.requestEncoding)
+			request.Header.Set("Content-Digest", iterTest.requestContentDigest)
+			request.Header.Set("Repr-Digest", iterTest.requestReprDigest)
+
+			compress(handlerWithoutEncoding).ServeHTTP(recorder, request)
+
+			response := recorder.Result()
+			defer response.Body.Close()
+
+			if response.StatusCode != iterTest.responseStatusCode {
+				t.Fatalf("unexpected response status code, want %d but got %d", iterTest.responseStatusCode, response.StatusCode)
+			}
+
+			if iterTest.responseStatusCode != returnedStatusCode {
+				return
+			}
+
+			if response.Header.Get("Content-Digest") != iterTest.responseContentDigest {
+				t.Fatalf("invalid Content-Digest header in response, want %s but got %s",
+					iterTest.responseContentDigest, response.Header.Get("Content-Digest"))
+			}
+
+			if response.Header.Get("Repr-Digest") != iterTest.responseReprDigest {
+				t.Fatalf("invalid Repr-Digest header in response, want %s but got %s",
+					iterTest.responseReprDigest, response.Header.Get("Repr-Digest"))
+			}
+		})
+	}
+}
+
+func TestDigestsOfRepresentation(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	compress := httpencoder.New(encoders, nil, httpencoder.WithDigests(httpencoder.DigestSHA256))
+
+	// the same representation gets the same digests whoever encoded it
+	for _, handlerEncoded := range []bool{false, true} {
+		handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+			if handlerEncoded {
+				responseWriter.Header().Set("Content-Encoding", "repeate")
+				_, _ = responseWriter.Write([]byte("tteesstt"))
+
+				return
+			}
+
+			_, _ = responseWriter.Write([]byte("test"))
+		})
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", "repeate")
+
+		compress(handler).ServeHTTP(recorder, request)
+
+		for _, field := range []string{"Content-Digest", "Repr-Digest"} {
+			if recorder.Header().Get(field) != sha256Field("tteesstt") {
+				test.Fatalf("invalid %s header in response encoded by handler %v, want %s but got %s",
+					field, handlerEncoded, sha256Field("tteesstt"), recorder.Header().Get(field))
+			}
+		}
+	}
+}
@@ -2,20 +2,44 @@ package httpencoder
 
 import (
 	"bytes"
+	"context"
+	"io"
+	"log/slog"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type wrappedWriter struct {
-	internalResponseWriter http.ResponseWriter
-	bufferedResponse       *bytes.Buffer
-	statusCode             *int
-}
+type (
+	wrappedWriter struct {
+		internalResponseWriter http.ResponseWriter
+		bufferedResponse       *bytes.Buffer
+		statusCode             *int
+		forcedEncoding         string
+		level                  int
+		disabled               bool
+		sensitive              bool
+	}
+
+	countingWriter struct {
+		writer  io.Writer
+		written int
+	}
+)
 
 const (
 	defaultQuality = 1000
 )
 
+//nolint:wrapcheck // there is simple counting wrapper, no need to wrap
+func (counter *countingWriter) Write(a []byte) (int, error) {
+	written, err := counter.writer.Write(a)
+	counter.written += written
+
+	return written, err
+}
+
 func (responseWriter *wrappedWriter) Header() http.Header {
 	return responseWriter.internalResponseWriter.Header()
 }
@@ -34,55 +58,217 @@ func (responseWriter *wrappedWriter) WriteHeader(statusCode int) {
 // func (*wrappedWriter) Flush() {
 // }
 
-func encode(bufferPool *sync.Pool, encoders map[string]Encoder, next http.Handler) http.Handler {
+func encode(cfg *config, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		request, policy := cfg.resolvePolicy(request)
+
 		header := compactAndLow([]byte(request.Header.Get("Accept-Encoding")))
-		if len(header) == 0 || request.Header.Get("Upgrade") != "" {
-			next.ServeHTTP(responseWriter, request)
+		if len(header) == 0 || request.Header.Get("Upgrade") != "" || policy.DisableEncode {
+			switch {
+			case request.Header.Get("Upgrade") != "":
+				// upgraded connection is hijacked, so response must not be buffered
+				cfg.skipped(request, SkipUpgrade)
+
+				next.ServeHTTP(responseWriter, request)
+			case len(header) == 0:
+				cfg.skipped(request, SkipNoAcceptEncoding)
+				cfg.serveIdentity(responseWriter, request, next, header, cfg.transcode)
+			default:
+				cfg.skipped(request, SkipDisabled)
+				cfg.serveIdentity(responseWriter, request, next, header, false)
+			}
 
 			return
 		}
 
-		encoder, encodingType := getPreferedEncoder(header, encoders)
+		negotiable := cfg.negotiable
+		if policy.Encoders != nil {
+			negotiable = policy.Encoders
+		}
+
+		var dictionary []byte
+		if cfg.dictionaries != nil {
+			dictionary, header = cfg.matchDictionary(request, header, negotiable)
+		}
+
+		encoder, encodingType := getPreferedEncoder(header, negotiable)
 		if encoder == nil {
-			next.ServeHTTP(responseWriter, request)
+			cfg.skipped(request, SkipNoEncoder)
+
+			cfg.serveIdentity(responseWriter, request, next, header, cfg.transcode)
 
 			return
 		}
 
+		withoutETagSuffixes(request.Header, cfg.acceptableEncodings(header, negotiable)...)
+
+		request = withResponseEncoding(request, encodingType)
+
+		cfg.negotiated(request.Context(), encodingType)
+
 		statusCode := http.StatusOK
 
-		upstreamResponse := bufferGet(bufferPool)
-		defer bufferPut(bufferPool, upstreamResponse)
+		upstreamResponse := bufferGet(cfg.bufferPool)
+		defer bufferPut(cfg.bufferPool, upstreamResponse)
 
-		next.ServeHTTP(&wrappedWriter{
+		wrapped := &wrappedWriter{
 			internalResponseWriter: responseWriter,
 			bufferedResponse:       upstreamResponse,
 			statusCode:             &statusCode,
-		}, request)
-
-		upstreamResponseBody := upstreamResponse.Bytes()
+			forcedEncoding:         "",
+			level:                  DefaultLevel,
+			disabled:               false,
+			sensitive:              false,
+		}
 
-		if responseWriter.Header().Get("Content-Encoding") != "" { // already encoded
-			responseWriter.WriteHeader(statusCode)
+		next.ServeHTTP(wrapped, request)
 
-			_, err := responseWriter.Write(upstreamResponseBody)
+		if cfg.transcode {
+			transcoded, err := cfg.transcodeUpstream(request, header, wrapped, upstreamResponse)
 			if err != nil {
 				http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+
+				return
 			}
 
+			if transcoded && !cfg.reencode {
+				cfg.storeDictionary(responseWriter.Header(), statusCode, upstreamResponse.Bytes())
+				cfg.writeIdentity(responseWriter, statusCode, upstreamResponse.Bytes())
+
+				return
+			}
+		}
+
+		upstreamResponseBody := upstreamResponse.Bytes()
+
+		cfg.storeDictionary(responseWriter.Header(), statusCode, upstreamResponseBody)
+
+		if reason := getSkipReason(wrapped); reason != "" {
+			cfg.skipped(request, reason, slog.Int("status", statusCode), slog.Int("size", len(upstreamResponseBody)))
+
+			cfg.writeIdentity(responseWriter, statusCode, upstreamResponseBody)
+
 			return
 		}
 
-		if responseWriter.Header().Get("Content-Type") == "" {
-			responseWriter.Header().Set("Content-Type", http.DetectContentType(upstreamResponseBody))
+		contentType := responseWriter.Header().Get("Content-Type")
+		if contentType == "" {
+			contentType = http.DetectContentType(upstreamResponseBody)
+			responseWriter.Header().Set("Content-Type", contentType)
 		}
 
-		responseWriter.Header().Set("Content-Encoding", encodingType)
-		responseWriter.Header().Del("Content-Length")
+		encoders, preference := negotiable, []string(nil)
+		if len(cfg.mediaTypes) > 0 && policy.Encoders == nil {
+			encoders, preference = cfg.encodersFor(contentType)
+
+			encoder, encodingType = getServerPreferedEncoder(header, encoders, preference)
+			if encoder == nil {
+				cfg.skipped(request, SkipNoEncoder, slog.Int("status", statusCode), slog.Int("size", len(upstreamResponseBody)))
+
+				cfg.writeIdentity(responseWriter, statusCode, upstreamResponseBody)
+
+				return
+			}
+		}
+
+		level := wrapped.level
+
+		switch {
+		case wrapped.forcedEncoding != "":
+			forcedEncoder, exist := encoders[wrapped.forcedEncoding]
+			if exist && acceptsEncoding(header, wrapped.forcedEncoding) {
+				encoder, encodingType = forcedEncoder, wrapped.forcedEncoding
+			}
+		case cfg.selector != nil:
+			acceptable := acceptablePreferences(header, encoders)
+
+			selected, selectedLevel := cfg.selector(acceptable, len(upstreamResponseBody), contentType)
+			if selectedEncoder, exist := encoders[selected]; exist && acceptsEncoding(header, selected) {
+				encoder, encodingType = selectedEncoder, selected
+
+				if level == DefaultLevel {
+					level = selectedLevel
+				}
+			}
+		}
+
+		encrypting := isEncrypting(encoder)
+
+		if !encrypting && len(upstreamResponseBody) < policy.MinSize {
+			cfg.skipped(request, SkipTooSmall, slog.Int("status", statusCode), slog.Int("size", len(upstreamResponseBody)))
+
+			cfg.writeUncompressed(responseWriter, request, header, statusCode, upstreamResponseBody)
+
+			return
+		}
+
+		if level == DefaultLevel {
+			level = cfg.adaptiveLevel(encodingType)
+		}
+
+		switch {
+		case encrypting:
+			// encryption is never dropped under load
+		case cfg.acquireEncode():
+			defer cfg.releaseEncode()
+		default:
+			if _, leveled := encoder.(LeveledEncoder); !leveled || cfg.degradedLevel == DefaultLevel {
+				fallback := identityEncoding
+				if cfg.acceptsStacked(header) {
+					fallback = cfg.stackedCoding
+				}
+
+				cfg.degraded(request, fallback)
+
+				cfg.writeUncompressed(responseWriter, request, header, statusCode, upstreamResponseBody)
+
+				return
+			}
+
+			level = cfg.degradedLevel
+
+			cfg.degraded(request, encodingType)
+		}
+
+		encoder = withDictionary(encoder, encodingType, dictionary)
+		stackedEncoder := cfg.stackedEncoder
+
+		if cfg.isSensitive(wrapped, upstreamResponseBody) {
+			paddedEncoder, padding := cfg.withPadding(encoder)
+			paddedStacked, stackedPadding := cfg.withStackedPadding(header, encodingType)
+
+			switch {
+			case paddedEncoder != nil:
+				encoder = paddedEncoder
+
+				cfg.padded(request.Context(), encodingType, padding)
+			case paddedStacked != nil:
+				// compressed body is hidden by random padding of stacked encryption
+				stackedEncoder = paddedStacked
+
+				cfg.padded(request.Context(), cfg.stackedCoding, stackedPadding)
+			case !encrypting:
+				cfg.skipped(request, SkipSensitive, slog.Int("status", statusCode), slog.Int("size", len(upstreamResponseBody)))
+
+				cfg.writeUncompressed(responseWriter, request, header, statusCode, upstreamResponseBody)
+
+				return
+			}
+		}
+
+		if cfg.bufferEncoded() {
+			cfg.writeBuffered(
+				responseWriter, request, header, encoder, stackedEncoder, encodingType, encrypting,
+				statusCode, upstreamResponseBody, level,
+			)
+
+			return
+		}
+
+		setEncodedHeaders(responseWriter.Header(), encodingType)
 		responseWriter.WriteHeader(statusCode)
 
-		err := encoder.Encode(request.Context(), responseWriter, upstreamResponseBody)
+		_, err := cfg.encodeBody(request, encoder, encodingType, responseWriter, upstreamResponseBody, level)
 		if err != nil {
 			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
 
@@ -91,6 +277,250 @@ func encode(bufferPool *sync.Pool, encoders map[string]Encoder, next http.Handle
 	})
 }
 
+// writeBuffered encodes body into scratch buffer before
+// http.ResponseWriter.WriteHeader call.
+func (cfg *config) writeBuffered(
+	responseWriter http.ResponseWriter, request *http.Request, acceptEncodingHeader []byte,
+	encoder, stackedEncoder Encoder, encodingType string, encrypting bool, statusCode int, body []byte, level int,
+) {
+	encodedResponse := bufferGet(cfg.bufferPool)
+	defer bufferPut(cfg.bufferPool, encodedResponse)
+
+	duration, err := cfg.encodeBody(request, encoder, encodingType, encodedResponse, body, level)
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	if !encrypting && !cfg.isSmallerEnough(encodedResponse.Len(), len(body)) {
+		cfg.skipped(request, SkipNotSmaller, slog.Int("status", statusCode), slog.Int("size", len(body)))
+
+		cfg.writeUncompressed(responseWriter, request, acceptEncodingHeader, statusCode, body)
+
+		return
+	}
+
+	if encodingType != cfg.stackedCoding && cfg.acceptsStacked(acceptEncodingHeader) {
+		stackedResponse := bufferGet(cfg.bufferPool)
+		defer bufferPut(cfg.bufferPool, stackedResponse)
+
+		stackedDuration, err := cfg.encodeBody(
+			request, stackedEncoder, cfg.stackedCoding, stackedResponse, encodedResponse.Bytes(), DefaultLevel,
+		)
+		if err != nil {
+			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		encodedResponse = stackedResponse
+		encodingType += ", " + cfg.stackedCoding
+		duration += stackedDuration
+	}
+
+	cfg.writeEncoded(responseWriter, encodingType, statusCode, encodedResponse.Bytes(), body, duration)
+}
+
+// writeUncompressed sends body, which compression was skipped for, encoded
+// with stacked encoder alone if client accepts it, so encryption is never
+// dropped together with compression.
+func (cfg *config) writeUncompressed(
+	responseWriter http.ResponseWriter, request *http.Request, acceptEncodingHeader []byte, statusCode int, body []byte,
+) {
+	if !cfg.acceptsStacked(acceptEncodingHeader) {
+		cfg.writeIdentity(responseWriter, statusCode, body)
+
+		return
+	}
+
+	stackedResponse := bufferGet(cfg.bufferPool)
+	defer bufferPut(cfg.bufferPool, stackedResponse)
+
+	duration, err := cfg.encodeBody(request, cfg.stackedEncoder, cfg.stackedCoding, stackedResponse, body, DefaultLevel)
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	cfg.writeEncoded(responseWriter, cfg.stackedCoding, statusCode, stackedResponse.Bytes(), body, duration)
+}
+
+// writeEncoded sends buffered encoded body with its headers.
+func (cfg *config) writeEncoded(
+	responseWriter http.ResponseWriter, encodingType string, statusCode int, encoded, body []byte, duration time.Duration,
+) {
+	if cfg.serverTiming {
+		responseWriter.Header().Add("Server-Timing", serverTiming("enc", encodingType, duration))
+	}
+
+	setEncodedHeaders(responseWriter.Header(), encodingType)
+	responseWriter.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	cfg.setDigests(responseWriter.Header(), encoded)
+
+	writeThrough(responseWriter, statusCode, encoded)
+}
+
+// acceptsStacked reports whether stacked encoder is set and accepted by client.
+func (cfg *config) acceptsStacked(acceptEncodingHeader []byte) bool {
+	return cfg.stackedEncoder != nil && acceptsEncoding(acceptEncodingHeader, cfg.stackedCoding)
+}
+
+// isEncrypting reports whether encoder is EncryptingEncoder, which must not be skipped.
+func isEncrypting(encoder Encoder) bool {
+	encrypting, okay := encoder.(EncryptingEncoder)
+
+	return okay && encrypting.Encrypts()
+}
+
+func setEncodedHeaders(header http.Header, encodingType string) {
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", withETagSuffix(etag, strings.ReplaceAll(encodingType, ", ", etagSuffixSeparator)))
+	}
+
+	addVary(header, "Accept-Encoding")
+	header.Set("Content-Encoding", encodingType)
+	header.Del("Content-Length")
+}
+
+func getSkipReason(wrapped *wrappedWriter) SkipReason {
+	switch {
+	case wrapped.disabled:
+		return SkipDisabled
+	case wrapped.Header().Get("Content-Encoding") != "":
+		return SkipAlreadyEncoded
+	case hasCacheDirective(wrapped.Header(), "no-transform"):
+		return SkipNoTransform
+	default:
+		return ""
+	}
+}
+
+// bufferEncoded reports whether encoded body must be buffered before
+// http.ResponseWriter.WriteHeader call.
+func (cfg *config) bufferEncoded() bool {
+	return cfg.serverTiming || cfg.checkSavings || cfg.stackedEncoder != nil || len(cfg.digests) > 0
+}
+
+// isSmallerEnough reports whether encoded body is worth to be sent
+// instead of original one according to WithMinSavings.
+func (cfg *config) isSmallerEnough(encodedSize, originalSize int) bool {
+	if !cfg.checkSavings {
+		return true
+	}
+
+	return encodedSize < originalSize && float64(encodedSize) <= float64(originalSize)*(1-cfg.minSavings)
+}
+
+func (cfg *config) encodeBody(
+	request *http.Request, encoder Encoder, coding string, to io.Writer, from []byte, level int,
+) (time.Duration, error) {
+	controller := cfg.adaptiveLevels[coding]
+
+	if cfg.hooks.OnEncoded == nil && !cfg.serverTiming && controller == nil {
+		err := encodeLevel(request.Context(), encoder, to, from, level)
+		if err != nil {
+			cfg.failed(request, coding, len(from), err)
+		}
+
+		return 0, err
+	}
+
+	counter := &countingWriter{writer: to, written: 0}
+	start := time.Now()
+
+	if controller != nil {
+		controller.start()
+	}
+
+	err := encodeLevel(request.Context(), encoder, counter, from, level)
+	duration := time.Since(start)
+
+	if controller != nil {
+		controller.done(duration)
+	}
+
+	if err != nil {
+		cfg.failed(request, coding, len(from), err)
+
+		return 0, err
+	}
+
+	cfg.encoded(request.Context(), coding, len(from), counter.written, duration)
+
+	return duration, nil
+}
+
+// serveIdentity calls next handler for response, which is not going to be
+// encoded, and buffers it only if digests have to be added, response
+// has to be transcoded or could be stored as dictionary.
+func (cfg *config) serveIdentity(
+	responseWriter http.ResponseWriter, request *http.Request, next http.Handler,
+	acceptEncodingHeader []byte, transcode bool,
+) {
+	if len(cfg.digests) == 0 && !transcode && cfg.dictionaries == nil {
+		next.ServeHTTP(responseWriter, request)
+
+		return
+	}
+
+	statusCode := http.StatusOK
+
+	upstreamResponse := bufferGet(cfg.bufferPool)
+	defer bufferPut(cfg.bufferPool, upstreamResponse)
+
+	wrapped := &wrappedWriter{
+		internalResponseWriter: responseWriter,
+		bufferedResponse:       upstreamResponse,
+		statusCode:             &statusCode,
+		forcedEncoding:         "",
+		level:                  DefaultLevel,
+		disabled:               false,
+		sensitive:              false,
+	}
+
+	next.ServeHTTP(wrapped, request)
+
+	if transcode {
+		_, err := cfg.transcodeUpstream(request, acceptEncodingHeader, wrapped, upstreamResponse)
+		if err != nil {
+			http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	cfg.storeDictionary(responseWriter.Header(), statusCode, upstreamResponse.Bytes())
+	cfg.writeIdentity(responseWriter, statusCode, upstreamResponse.Bytes())
+}
+
+// writeIdentity sends body as is with digests if they are enabled.
+func (cfg *config) writeIdentity(responseWriter http.ResponseWriter, statusCode int, body []byte) {
+	cfg.setDigests(responseWriter.Header(), body)
+
+	writeThrough(responseWriter, statusCode, body)
+}
+
+func writeThrough(responseWriter http.ResponseWriter, statusCode int, body []byte) {
+	responseWriter.WriteHeader(statusCode)
+
+	_, err := responseWriter.Write(body)
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func encodeLevel(ctx context.Context, encoder Encoder, to io.Writer, from []byte, level int) error {
+	if level != DefaultLevel {
+		if leveledEncoder, okay := encoder.(LeveledEncoder); okay {
+			return leveledEncoder.EncodeLevel(ctx, to, from, level)
+		}
+	}
+
+	return encoder.Encode(ctx, to, from)
+}
+
 //nolint:ireturn // helper function
 func getPreferedEncoder(acceptEncodingHeader []byte, encoders map[string]Encoder) (Encoder, string) {
 	var (
@@ -116,14 +546,32 @@ func getPreferedEncoder(acceptEncodingHeader []byte, encoders map[string]Encoder
 	return preferedEncodingFunc, preferedEncodingType
 }
 
+func acceptsEncoding(acceptEncodingHeader []byte, encodingType string) bool {
+	var (
+		currentType  string
+		qualityValue int
+	)
+
+	for pos := 0; pos < len(acceptEncodingHeader); pos++ {
+		currentType, pos = getNextAcceptEncodingType(acceptEncodingHeader, pos)
+		qualityValue, pos = getNextQualityValue(acceptEncodingHeader, pos)
+
+		if currentType == encodingType && qualityValue > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getNextAcceptEncodingType(header []byte, start int) (encodingType string, newPosition int) {
-	for start < len(header) && !isAlpha(header[start]) {
+	for start < len(header) && !isTokenChar(header[start]) {
 		start++
 	}
 
 	end := start
 
-	for end < len(header) && isAlpha(header[end]) {
+	for end < len(header) && isTokenChar(header[end]) {
 		end++
 	}
 
@@ -141,12 +589,20 @@ func getNextQualityValue(header []byte, pos int) (quality, newPosition int) {
 		return defaultQuality, pos
 	}
 
-	if header[pos] == '1' || header[pos] != '0' {
+	if header[pos] != '0' {
+		// skip "1", "1.000" or malformed value
+		for pos < len(header) && (isDigit(header[pos]) || header[pos] == '.') {
+			pos++
+		}
+
 		return defaultQuality, pos
 	}
 
-	// skip ";0"
-	pos += 2
+	// skip "0" and optional "."
+	pos++
+	if pos < len(header) && header[pos] == '.' {
+		pos++
+	}
 
 	return parseQuality(header, pos)
 }
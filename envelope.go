@@ -0,0 +1,465 @@
+package httpencoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// envelopeProtocol describes RPC protocol, which compresses
+	// length-prefixed messages, called envelopes, individually.
+	envelopeProtocol struct {
+		encodingHeader       string
+		acceptEncodingHeader string
+		// rawFlags marks envelopes sent as is, like gRPC-Web trailers.
+		rawFlags byte
+	}
+
+	// envelopeReader decompresses length-prefixed messages of request body.
+	envelopeReader struct {
+		cfg            *config
+		request        *http.Request
+		body           io.ReadCloser
+		decoder        Decoder
+		coding         string
+		maxDecodedSize int64
+		pending        bytes.Buffer
+		message        bytes.Buffer
+	}
+
+	// envelopeWriter compresses length-prefixed messages of response body
+	// as soon as they are completely written by handler.
+	envelopeWriter struct {
+		internalResponseWriter http.ResponseWriter
+		cfg                    *config
+		request                *http.Request
+		encoder                Encoder
+		coding                 string
+		protocol               envelopeProtocol
+		minSize                int
+		pending                bytes.Buffer
+		encoded                bytes.Buffer
+		prepared               bool
+		passthrough            bool
+	}
+)
+
+const (
+	envelopeCompressedFlag  = 0x01
+	envelopeHeaderSize      = 5
+	grpcWebTrailersFlag     = 0x80
+	connectContentTypeStart = "application/connect+"
+)
+
+// WithGRPC makes middleware compress and decompress individual messages of
+// gRPC requests, which Content-Type is application/grpc, with registered
+// Encoders and Decoders according to grpc-encoding and grpc-accept-encoding
+// headers instead of Content-Encoding ones. Messages are streamed, so
+// unary and streaming calls, handler's Flush and trailers work as usual.
+// Policy limits, MinSize and WithEncodeConcurrency are applied per message.
+func WithGRPC() Option {
+	return func(cfg *config) {
+		cfg.grpc = true
+	}
+}
+
+// WithConnect makes middleware compress and decompress individual messages
+// of Connect streaming requests, which Content-Type is application/connect+*,
+// according to Connect-Content-Encoding and Connect-Accept-Encoding headers,
+// and of gRPC-Web requests, which Content-Type is application/grpc-web or
+// application/grpc-web+*, like WithGRPC does. Connect unary requests use
+// Content-Encoding and Accept-Encoding, so they are served as any other ones.
+func WithConnect() Option {
+	return func(cfg *config) {
+		cfg.connect = true
+	}
+}
+
+// envelopeProtocolOf returns enabled envelope protocol of request.
+func (cfg *config) envelopeProtocolOf(request *http.Request) (envelopeProtocol, bool) {
+	contentType := strings.ToLower(request.Header.Get("Content-Type"))
+	if end := strings.IndexByte(contentType, ';'); end >= 0 {
+		contentType = contentType[:end]
+	}
+
+	contentType = strings.TrimSpace(contentType)
+
+	switch {
+	case cfg.grpc && (contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+")):
+		return envelopeProtocol{
+			encodingHeader:       "Grpc-Encoding",
+			acceptEncodingHeader: "Grpc-Accept-Encoding",
+			rawFlags:             0,
+		}, true
+	case cfg.connect && (contentType == "application/grpc-web" || strings.HasPrefix(contentType, "application/grpc-web+")):
+		return envelopeProtocol{
+			encodingHeader:       "Grpc-Encoding",
+			acceptEncodingHeader: "Grpc-Accept-Encoding",
+			rawFlags:             grpcWebTrailersFlag,
+		}, true
+	case cfg.connect && strings.HasPrefix(contentType, connectContentTypeStart):
+		return envelopeProtocol{
+			encodingHeader:       "Connect-Content-Encoding",
+			acceptEncodingHeader: "Connect-Accept-Encoding",
+			rawFlags:             0,
+		}, true
+	default:
+		return envelopeProtocol{}, false
+	}
+}
+
+// envelope serves requests of enabled envelope protocols with next
+// handler and passes other ones to fallback handler.
+func envelope(cfg *config, next, fallback http.Handler) http.Handler {
+	return http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		protocol, okay := cfg.envelopeProtocolOf(request)
+		if !okay {
+			fallback.ServeHTTP(responseWriter, request)
+
+			return
+		}
+
+		request, policy := cfg.resolvePolicy(request)
+
+		if policy.MaxRequestBodySize > 0 && request.Body != nil {
+			request.Body = http.MaxBytesReader(responseWriter, request.Body, policy.MaxRequestBodySize)
+		}
+
+		encoders, decoders := cfg.negotiable, cfg.decoders
+		if policy.Encoders != nil {
+			encoders = policy.Encoders
+		}
+
+		if policy.Decoders != nil {
+			decoders = policy.Decoders
+		}
+
+		if !policy.DisableDecode {
+			request = cfg.envelopeDecodeRequest(request, protocol, decoders, policy.MaxDecodedSize)
+
+			responseWriter.Header().Set(protocol.acceptEncodingHeader, strings.Join(sortedCodings(decoders), ","))
+		}
+
+		header := compactAndLow([]byte(request.Header.Get(protocol.acceptEncodingHeader)))
+		if len(header) == 0 || policy.DisableEncode {
+			if policy.DisableEncode {
+				cfg.skipped(request, SkipDisabled)
+			} else {
+				cfg.skipped(request, SkipNoAcceptEncoding)
+			}
+
+			next.ServeHTTP(responseWriter, request)
+
+			return
+		}
+
+		encoder, encodingType := getPreferedEncoder(header, encoders)
+		if encoder == nil {
+			cfg.skipped(request, SkipNoEncoder)
+
+			next.ServeHTTP(responseWriter, request)
+
+			return
+		}
+
+		request.Header.Del(protocol.acceptEncodingHeader)
+
+		request = withResponseEncoding(request, encodingType)
+
+		cfg.negotiated(request.Context(), encodingType)
+
+		wrapped := &envelopeWriter{
+			internalResponseWriter: responseWriter,
+			cfg:                    cfg,
+			request:                request,
+			encoder:                encoder,
+			coding:                 encodingType,
+			protocol:               protocol,
+			minSize:                policy.MinSize,
+			pending:                bytes.Buffer{},
+			encoded:                bytes.Buffer{},
+			prepared:               false,
+			passthrough:            false,
+		}
+
+		next.ServeHTTP(wrapped, request)
+
+		wrapped.close()
+	})
+}
+
+// envelopeDecodeRequest makes request body decompress messages with
+// registered Decoder, or leaves request as is for unknown coding.
+func (cfg *config) envelopeDecodeRequest(
+	request *http.Request, protocol envelopeProtocol, decoders map[string]Decoder, maxDecodedSize int64,
+) *http.Request {
+	coding := strings.ToLower(strings.TrimSpace(request.Header.Get(protocol.encodingHeader)))
+	if coding == "" || coding == identityEncoding {
+		return request
+	}
+
+	decoder, exist := decoders[coding]
+	if !exist {
+		cfg.skipped(request, SkipUnknownEncoding, slog.String("coding", coding))
+
+		return request
+	}
+
+	request = withDecodedEncodings(request, []string{coding})
+	request.Header.Del(protocol.encodingHeader)
+	request.Header.Del("Content-Length")
+	request.ContentLength = -1
+	request.Body = &envelopeReader{
+		cfg:            cfg,
+		request:        request,
+		body:           request.Body,
+		decoder:        decoder,
+		coding:         coding,
+		maxDecodedSize: maxDecodedSize,
+		pending:        bytes.Buffer{},
+		message:        bytes.Buffer{},
+	}
+
+	return request
+}
+
+func sortedCodings(decoders map[string]Decoder) []string {
+	codings := make([]string, 0, len(decoders)+1)
+	for coding := range decoders {
+		codings = append(codings, coding)
+	}
+
+	sort.Strings(codings)
+
+	return append(codings, identityEncoding)
+}
+
+//nolint:wrapcheck // body errors are returned as is for handler
+func (reader *envelopeReader) Read(p []byte) (int, error) {
+	for reader.pending.Len() == 0 {
+		err := reader.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return reader.pending.Read(p)
+}
+
+//nolint:wrapcheck // there is simple body wrapper, no need to wrap
+func (reader *envelopeReader) Close() error {
+	return reader.body.Close()
+}
+
+// next reads next message and puts its decompressed frame into pending buffer.
+func (reader *envelopeReader) next() error {
+	var header [envelopeHeaderSize]byte
+
+	_, err := io.ReadFull(reader.body, header[:])
+	if err != nil {
+		return reader.bodyError(err)
+	}
+
+	reader.message.Reset()
+
+	length := int64(binary.BigEndian.Uint32(header[1:]))
+
+	copied, err := io.CopyN(&reader.message, reader.body, length)
+	if copied < length {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return reader.bodyError(err)
+	}
+
+	reader.pending.Reset()
+
+	if header[0]&envelopeCompressedFlag == 0 {
+		reader.pending.Write(header[:])
+		reader.pending.Write(reader.message.Bytes())
+
+		return nil
+	}
+
+	// reserve frame header to fill it after decoding
+	reader.pending.Write(make([]byte, envelopeHeaderSize))
+
+	var decodedMessage io.Writer = &reader.pending
+	if reader.maxDecodedSize > 0 {
+		decodedMessage = &limitedWriter{buffer: &reader.pending, remaining: reader.maxDecodedSize}
+	}
+
+	start := time.Now()
+
+	err = reader.decoder.Decode(reader.request.Context(), decodedMessage, reader.message.Bytes())
+	if errors.Is(err, ErrTooLarge) {
+		reader.pending.Reset()
+		reader.cfg.limited(reader.request, reader.coding, reader.maxDecodedSize)
+
+		return err
+	}
+
+	if err != nil {
+		reader.pending.Reset()
+		reader.cfg.failed(reader.request, reader.coding, reader.message.Len(), err)
+
+		return err
+	}
+
+	frame := reader.pending.Bytes()
+	frame[0] = header[0] &^ envelopeCompressedFlag
+	binary.BigEndian.PutUint32(frame[1:envelopeHeaderSize], uint32(len(frame)-envelopeHeaderSize))
+
+	reader.cfg.decoded(reader.request.Context(), reader.coding, reader.message.Len(), len(frame)-envelopeHeaderSize, time.Since(start))
+
+	return nil
+}
+
+func (reader *envelopeReader) bodyError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		reader.cfg.limited(reader.request, "", maxBytesError.Limit)
+	}
+
+	return err
+}
+
+func (writer *envelopeWriter) Header() http.Header {
+	return writer.internalResponseWriter.Header()
+}
+
+func (writer *envelopeWriter) WriteHeader(statusCode int) {
+	writer.prepare()
+	writer.internalResponseWriter.WriteHeader(statusCode)
+}
+
+func (writer *envelopeWriter) Write(p []byte) (int, error) {
+	writer.prepare()
+
+	if writer.passthrough {
+		return writer.internalResponseWriter.Write(p) //nolint:wrapcheck // there is simple passthrough
+	}
+
+	writer.pending.Write(p)
+
+	err := writer.writeFrames()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush sends completely written messages to client.
+func (writer *envelopeWriter) Flush() {
+	writer.prepare()
+
+	if flusher, okay := writer.internalResponseWriter.(http.Flusher); okay {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns original http.ResponseWriter for http.ResponseController.
+func (writer *envelopeWriter) Unwrap() http.ResponseWriter {
+	return writer.internalResponseWriter
+}
+
+// prepare sets response encoding header of protocol before response
+// headers are sent, unless handler compresses messages itself.
+func (writer *envelopeWriter) prepare() {
+	if writer.prepared {
+		return
+	}
+
+	writer.prepared = true
+
+	if writer.Header().Get(writer.protocol.encodingHeader) != "" {
+		writer.passthrough = true
+
+		return
+	}
+
+	writer.Header().Set(writer.protocol.encodingHeader, writer.coding)
+}
+
+func (writer *envelopeWriter) writeFrames() error {
+	for writer.pending.Len() >= envelopeHeaderSize {
+		length := int(binary.BigEndian.Uint32(writer.pending.Bytes()[1:envelopeHeaderSize]))
+		if writer.pending.Len() < envelopeHeaderSize+length {
+			return nil
+		}
+
+		frame := writer.pending.Next(envelopeHeaderSize + length)
+
+		err := writer.writeFrame(frame)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//nolint:wrapcheck // there is simple framing wrapper, no need to wrap
+func (writer *envelopeWriter) writeFrame(frame []byte) error {
+	message := frame[envelopeHeaderSize:]
+
+	if frame[0]&(envelopeCompressedFlag|writer.protocol.rawFlags) != 0 || len(message) < writer.minSize {
+		_, err := writer.internalResponseWriter.Write(frame)
+
+		return err
+	}
+
+	level := DefaultLevel
+
+	if writer.cfg.acquireEncode() {
+		defer writer.cfg.releaseEncode()
+	} else {
+		if _, leveled := writer.encoder.(LeveledEncoder); !leveled || writer.cfg.degradedLevel == DefaultLevel {
+			writer.cfg.degraded(writer.request, identityEncoding)
+
+			// uncompressed message is allowed even with response encoding set
+			_, err := writer.internalResponseWriter.Write(frame)
+
+			return err
+		}
+
+		level = writer.cfg.degradedLevel
+
+		writer.cfg.degraded(writer.request, writer.coding)
+	}
+
+	writer.encoded.Reset()
+	writer.encoded.Write(make([]byte, envelopeHeaderSize))
+
+	_, err := writer.cfg.encodeBody(writer.request, writer.encoder, writer.coding, &writer.encoded, message, level)
+	if err != nil || writer.encoded.Len() >= len(frame) {
+		// uncompressed message is allowed even with response encoding set
+		_, err = writer.internalResponseWriter.Write(frame)
+
+		return err
+	}
+
+	encodedFrame := writer.encoded.Bytes()
+	encodedFrame[0] = frame[0] | envelopeCompressedFlag
+	binary.BigEndian.PutUint32(encodedFrame[1:envelopeHeaderSize], uint32(len(encodedFrame)-envelopeHeaderSize))
+
+	_, err = writer.internalResponseWriter.Write(encodedFrame)
+
+	return err
+}
+
+// close sends incomplete message left by handler as is.
+func (writer *envelopeWriter) close() {
+	if writer.pending.Len() > 0 {
+		_, _ = writer.internalResponseWriter.Write(writer.pending.Bytes())
+	}
+}
@@ -0,0 +1,260 @@
+package httpencoder_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func envelopeFrame(flag byte, message []byte) []byte {
+	frame := make([]byte, 5, 5+len(message))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+
+	return append(frame, message...)
+}
+
+func TestGRPC(test *testing.T) {
+	test.Parallel()
+
+	// streaming echo handler, which writes messages by parts
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("Content-Type", "application/grpc")
+		responseWriter.Header().Set("X-Request-Encoding", request.Header.Get("Grpc-Encoding"))
+		responseWriter.WriteHeader(http.StatusOK)
+
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			responseWriter.Header().Set(http.TrailerPrefix+"Grpc-Status", "13")
+
+			return
+		}
+
+		for len(body) > 0 {
+			part := min(3, len(body))
+
+			_, _ = responseWriter.Write(body[:part])
+			responseWriter.(http.Flusher).Flush()
+
+			body = body[part:]
+		}
+
+		responseWriter.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	})
+
+	compress := httpencoder.New(
+		map[string]httpencoder.Encoder{"repeate": repeater{}, "half": halver{}},
+		map[string]httpencoder.Decoder{"repeate": repeater{}},
+		httpencoder.WithGRPC(),
+	)
+
+	repeated := &bytes.Buffer{}
+	_ = repeater{}.Encode(context.Background(), repeated, []byte(testString))
+
+	halved := &bytes.Buffer{}
+	_ = halver{}.Encode(context.Background(), halved, []byte(testString))
+
+	plainMessages := append(envelopeFrame(0, []byte(testString)), envelopeFrame(0, []byte(testString))...)
+	compressedMessages := append(envelopeFrame(1, halved.Bytes()), envelopeFrame(1, halved.Bytes())...)
+
+	tests := []struct {
+		testName           string
+		requestEncoding    string
+		acceptEncoding     string
+		requestBody        []byte
+		handlerEncoding    string
+		responseEncoding   string
+		responseBody       []byte
+		responseGRPCStatus string
+	}{
+		{
+			testName:           "compressed request and response",
+			requestEncoding:    "repeate",
+			acceptEncoding:     "half",
+			requestBody:        append(envelopeFrame(1, repeated.Bytes()), envelopeFrame(0, []byte(testString))...),
+			handlerEncoding:    "",
+			responseEncoding:   "half",
+			responseBody:       compressedMessages,
+			responseGRPCStatus: "0",
+		}, {
+			testName:           "client does not accept compression",
+			requestEncoding:    "repeate",
+			acceptEncoding:     "",
+			requestBody:        envelopeFrame(1, repeated.Bytes()),
+			handlerEncoding:    "",
+			responseEncoding:   "",
+			responseBody:       envelopeFrame(0, []byte(testString)),
+			responseGRPCStatus: "0",
+		}, {
+			testName:           "uncompressed request",
+			requestEncoding:    "",
+			acceptEncoding:     "identity, half",
+			requestBody:        plainMessages,
+			handlerEncoding:    "",
+			responseEncoding:   "half",
+			responseBody:       compressedMessages,
+			responseGRPCStatus: "0",
+		}, {
+			testName:           "not smaller message is sent uncompressed",
+			requestEncoding:    "",
+			acceptEncoding:     "repeate",
+			requestBody:        plainMessages,
+			handlerEncoding:    "",
+			responseEncoding:   "repeate",
+			responseBody:       plainMessages,
+			responseGRPCStatus: "0",
+		}, {
+			testName:           "unknown request coding is passed to handler",
+			requestEncoding:    "zstd",
+			acceptEncoding:     "",
+			requestBody:        envelopeFrame(1, []byte(testString)),
+			handlerEncoding:    "zstd",
+			responseEncoding:   "",
+			responseBody:       envelopeFrame(1, []byte(testString)),
+			responseGRPCStatus: "0",
+		}, {
+			testName:           "truncated request",
+			requestEncoding:    "repeate",
+			acceptEncoding:     "repeate",
+			requestBody:        envelopeFrame(1, repeated.Bytes())[:8],
+			handlerEncoding:    "",
+			responseEncoding:   "repeate",
+			responseBody:       nil,
+			responseGRPCStatus: "13",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/service/method", bytes.NewReader(iterTest.requestBody))
+			request.Header.Set("Content-Type", "application/grpc+proto")
+			request.Header.Set("Grpc-Encoding", iterTest.requestEncoding)
+			request.Header.Set("Grpc-Accept-Encoding", iterTest.acceptEncoding)
+
+			compress(handler).ServeHTTP(recorder, request)
+
+			response := recorder.Result()
+			defer response.Body.Close()
+
+			if response.Header.Get("X-Request-Encoding") != iterTest.handlerEncoding {
+				t.Fatalf("invalid grpc-encoding header passed to handler, want %s but got %s",
+					iterTest.handlerEncoding, response.Header.Get("X-Request-Encoding"))
+			}
+
+			if response.Header.Get("Grpc-Encoding") != iterTest.responseEncoding {
+				t.Fatalf("invalid grpc-encoding header in response, want %s but got %s",
+					iterTest.responseEncoding, response.Header.Get("Grpc-Encoding"))
+			}
+
+			if response.Header.Get("Content-Encoding") != "" {
+				t.Fatalf("unexpected Content-Encoding header in response: %s", response.Header.Get("Content-Encoding"))
+			}
+
+			body, _ := io.ReadAll(response.Body)
+			if !bytes.Equal(body, iterTest.responseBody) {
+				t.Fatalf("invalid response body, want %v but got %v", iterTest.responseBody, body)
+			}
+
+			if response.Trailer.Get("Grpc-Status") != iterTest.responseGRPCStatus {
+				t.Fatalf("invalid grpc-status trailer, want %s but got %s", iterTest.responseGRPCStatus, response.Trailer.Get("Grpc-Status"))
+			}
+		})
+	}
+}
+
+func TestConnect(test *testing.T) {
+	test.Parallel()
+
+	echo := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+
+		responseWriter.Header().Set("X-Request-Encoding",
+			request.Header.Get("Content-Encoding")+request.Header.Get("Connect-Content-Encoding")+request.Header.Get("Grpc-Encoding"))
+
+		_, _ = responseWriter.Write(body)
+	})
+
+	compress := httpencoder.New(
+		map[string]httpencoder.Encoder{"half": halver{}},
+		map[string]httpencoder.Decoder{"repeate": repeater{}},
+		httpencoder.WithConnect(),
+	)
+
+	repeated := &bytes.Buffer{}
+	_ = repeater{}.Encode(context.Background(), repeated, []byte(testString))
+
+	halved := &bytes.Buffer{}
+	_ = halver{}.Encode(context.Background(), halved, []byte(testString))
+
+	tests := []struct {
+		testName             string
+		contentType          string
+		encodingHeader       string
+		acceptEncodingHeader string
+		requestBody          []byte
+		responseBody         []byte
+	}{
+		{
+			testName:             "connect streaming compresses end-stream message",
+			contentType:          "application/connect+proto",
+			encodingHeader:       "Connect-Content-Encoding",
+			acceptEncodingHeader: "Connect-Accept-Encoding",
+			requestBody:          append(envelopeFrame(1, repeated.Bytes()), envelopeFrame(2, []byte(testString))...),
+			responseBody:         append(envelopeFrame(1, halved.Bytes()), envelopeFrame(3, halved.Bytes())...),
+		}, {
+			testName:             "grpc-web leaves trailers frame as is",
+			contentType:          "application/grpc-web+proto",
+			encodingHeader:       "Grpc-Encoding",
+			acceptEncodingHeader: "Grpc-Accept-Encoding",
+			requestBody:          append(envelopeFrame(1, repeated.Bytes()), envelopeFrame(0x80, []byte(testString))...),
+			responseBody:         append(envelopeFrame(1, halved.Bytes()), envelopeFrame(0x80, []byte(testString))...),
+		}, {
+			testName:             "connect unary uses content-encoding",
+			contentType:          "application/proto",
+			encodingHeader:       "Content-Encoding",
+			acceptEncodingHeader: "Accept-Encoding",
+			requestBody:          repeated.Bytes(),
+			responseBody:         halved.Bytes(),
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/service/method", bytes.NewReader(iterTest.requestBody))
+			request.Header.Set("Content-Type", iterTest.contentType)
+			request.Header.Set(iterTest.encodingHeader, "repeate")
+			request.Header.Set(iterTest.acceptEncodingHeader, "half")
+
+			compress(echo).ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("X-Request-Encoding") != "" {
+				t.Fatalf("request is not decoded: %s", recorder.Header().Get("X-Request-Encoding"))
+			}
+
+			if recorder.Header().Get(iterTest.encodingHeader) != "half" {
+				t.Fatalf("invalid %s header in response, want half but got %s",
+					iterTest.encodingHeader, recorder.Header().Get(iterTest.encodingHeader))
+			}
+
+			if !bytes.Equal(recorder.Body.Bytes(), iterTest.responseBody) {
+				t.Fatalf("invalid response body, want %v but got %v", iterTest.responseBody, recorder.Body.Bytes())
+			}
+		})
+	}
+}
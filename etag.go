@@ -0,0 +1,151 @@
+package httpencoder
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	etagSuffixSeparator = "-"
+	weakETagPrefix      = "W/"
+)
+
+// withETagSuffix derives validator for encoded representation
+// by appending "-coding" to opaque-tag, so "abc" becomes "abc-gzip".
+func withETagSuffix(etag, coding string) string {
+	if len(etag) < 2 || etag[len(etag)-1] != '"' {
+		return etag
+	}
+
+	return etag[:len(etag)-1] + etagSuffixSeparator + coding + `"`
+}
+
+// acceptableEncodings returns every response encoding, which could be sent
+// for request after handler call, like Force, WithSelector or media type
+// encoders pick other coding, or stacked encoder is applied: registered
+// codings accepted by client, alone and with stacked coding, longer first.
+func (cfg *config) acceptableEncodings(acceptEncodingHeader []byte, encoders map[string]Encoder) []string {
+	stacked := cfg.acceptsStacked(acceptEncodingHeader)
+
+	encodings := make([]string, 0, len(encoders)+1)
+	if stacked {
+		encodings = append(encodings, cfg.stackedCoding)
+	}
+
+	for coding := range encoders {
+		if coding == cfg.stackedCoding || !acceptsEncoding(acceptEncodingHeader, coding) {
+			continue
+		}
+
+		encodings = append(encodings, coding)
+
+		if stacked {
+			encodings = append(encodings, coding+", "+cfg.stackedCoding)
+		}
+	}
+
+	sort.Slice(encodings, func(i, j int) bool {
+		if len(encodings[i]) != len(encodings[j]) {
+			return len(encodings[i]) > len(encodings[j])
+		}
+
+		return encodings[i] < encodings[j]
+	})
+
+	return encodings
+}
+
+// withoutETagSuffixes maps validators from conditional request headers
+// back to validators of unencoded representation by removing suffix of
+// any of provided response encodings, so "abc-gzip" becomes "abc" only if
+// gzip could be sent for request. Longer encodings have to go first.
+func withoutETagSuffixes(header http.Header, encodings ...string) {
+	suffixes := make([]string, 0, len(encodings))
+	for _, encoding := range encodings {
+		suffixes = append(suffixes, etagSuffixSeparator+strings.ReplaceAll(encoding, ", ", etagSuffixSeparator))
+	}
+
+	for _, name := range [...]string{"If-None-Match", "If-Match"} {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		stripped, changed := stripETagSuffixes(value, suffixes)
+		if changed {
+			header.Set(name, stripped)
+		}
+	}
+}
+
+func stripETagSuffixes(value string, suffixes []string) (string, bool) {
+	var (
+		builder strings.Builder
+		changed bool
+	)
+
+	for pos := 0; pos < len(value); {
+		for pos < len(value) && (value[pos] == ' ' || value[pos] == '\t' || value[pos] == ',') {
+			pos++
+		}
+
+		if pos >= len(value) {
+			break
+		}
+
+		start := pos
+
+		if strings.HasPrefix(value[pos:], weakETagPrefix) {
+			pos += len(weakETagPrefix)
+		}
+
+		if pos >= len(value) || value[pos] != '"' {
+			// "*" or malformed entity-tag, keep the rest untouched
+			end := strings.IndexByte(value[pos:], ',')
+			if end < 0 {
+				end = len(value) - pos
+			}
+
+			appendETag(&builder, value[start:pos+end])
+			pos += end
+
+			continue
+		}
+
+		end := strings.IndexByte(value[pos+1:], '"')
+		if end < 0 {
+			appendETag(&builder, value[start:])
+
+			break
+		}
+
+		opaque := value[pos+1 : pos+1+end]
+		pos += end + 2
+
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(opaque, suffix) {
+				opaque = opaque[:len(opaque)-len(suffix)]
+				changed = true
+
+				break
+			}
+		}
+
+		if strings.HasPrefix(value[start:], weakETagPrefix) {
+			appendETag(&builder, weakETagPrefix+`"`+opaque+`"`)
+		} else {
+			appendETag(&builder, `"`+opaque+`"`)
+		}
+	}
+
+	return builder.String(), changed
+}
+
+func appendETag(builder *strings.Builder, etag string) {
+	if builder.Len() > 0 {
+		builder.WriteString(", ")
+	}
+
+	builder.WriteString(etag)
+}
@@ -0,0 +1,228 @@
+package httpencoder_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func TestETag(test *testing.T) {
+	test.Parallel()
+
+	const upstreamETag = `"v1"`
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("ETag", upstreamETag)
+
+		if request.Header.Get("If-None-Match") == upstreamETag {
+			responseWriter.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		_, _ = responseWriter.Write([]byte(testString))
+	})
+
+	compress := httpencoder.New(map[string]httpencoder.Encoder{"repeate": repeater{}, "quadro": repeater2{}}, nil)
+
+	tests := []struct {
+		testName           string
+		acceptEncoding     string
+		ifNoneMatch        string
+		responseETag       string
+		responseVary       string
+		responseStatusCode int
+	}{
+		{
+			testName:           "vanilla response keeps etag",
+			acceptEncoding:     "",
+			ifNoneMatch:        "",
+			responseETag:       upstreamETag,
+			responseVary:       "",
+			responseStatusCode: http.StatusOK,
+		}, {
+			testName:           "encoded response gets suffixed etag",
+			acceptEncoding:     "repeate",
+			ifNoneMatch:        "",
+			responseETag:       `"v1-repeate"`,
+			responseVary:       "Accept-Encoding",
+			responseStatusCode: http.StatusOK,
+		}, {
+			testName:           "suffixed if-none-match maps back to upstream etag",
+			acceptEncoding:     "repeate",
+			ifNoneMatch:        `"v1-repeate"`,
+			responseETag:       `"v1-repeate"`,
+			responseVary:       "Accept-Encoding",
+			responseStatusCode: http.StatusNotModified,
+		}, {
+			testName:           "weak and unknown suffixes are kept",
+			acceptEncoding:     "repeate",
+			ifNoneMatch:        `W/"v1-repeate", "v1-fake"`,
+			responseETag:       `"v1-repeate"`,
+			responseVary:       "Accept-Encoding",
+			responseStatusCode: http.StatusOK,
+		}, {
+			testName:           "suffix of not negotiated coding is kept",
+			acceptEncoding:     "repeate",
+			ifNoneMatch:        `"v1-quadro"`,
+			responseETag:       `"v1-repeate"`,
+			responseVary:       "Accept-Encoding",
+			responseStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			if iterTest.ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", iterTest.ifNoneMatch)
+			}
+
+			compress(handler).ServeHTTP(recorder, request)
+
+			response := recorder.Result()
+			defer response.Body.Close()
+
+			if response.StatusCode != iterTest.responseStatusCode {
+				t.Fatalf("unexpected response status code, want %d but got %d", iterTest.responseStatusCode, response.StatusCode)
+			}
+
+			if response.Header.Get("ETag") != iterTest.responseETag {
+				t.Fatalf("invalid ETag header in response, want %s but got %s", iterTest.responseETag, response.Header.Get("ETag"))
+			}
+
+			if response.Header.Get("Vary") != iterTest.responseVary {
+				t.Fatalf("invalid Vary header in response, want %s but got %s", iterTest.responseVary, response.Header.Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestStackedETag(test *testing.T) {
+	test.Parallel()
+
+	const upstreamETag = `"v1"`
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("ETag", upstreamETag)
+
+		if request.Header.Get("If-None-Match") == upstreamETag {
+			responseWriter.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		_, _ = responseWriter.Write([]byte(testString))
+	})
+
+	compress := httpencoder.New(
+		map[string]httpencoder.Encoder{"repeate": repeater{}}, nil, httpencoder.WithStackedEncoder("quadro", repeater2{}),
+	)
+
+	for _, ifNoneMatch := range []string{`"v1-repeate-quadro"`, `"v1-quadro"`} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", "repeate, quadro")
+		request.Header.Set("If-None-Match", ifNoneMatch)
+
+		compress(handler).ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusNotModified {
+			test.Fatalf("unexpected response status code for %s, want %d but got %d",
+				ifNoneMatch, http.StatusNotModified, recorder.Code)
+		}
+	}
+}
+
+func TestETagOfFinalEncoding(test *testing.T) {
+	test.Parallel()
+
+	const upstreamETag = `"v1"`
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}, "quadro": repeater2{}}
+
+	tests := []struct {
+		testName string
+		encoders map[string]httpencoder.Encoder
+		opts     []httpencoder.Option
+		force    bool
+	}{
+		{
+			testName: "forced by handler",
+			encoders: encoders,
+			opts:     nil,
+			force:    true,
+		}, {
+			testName: "picked by selector",
+			encoders: encoders,
+			opts: []httpencoder.Option{
+				httpencoder.WithSelector(func([]httpencoder.Preference, int, string) (string, int) {
+					return "quadro", httpencoder.DefaultLevel
+				}),
+			},
+			force: false,
+		}, {
+			testName: "picked by media type",
+			encoders: map[string]httpencoder.Encoder{"repeate": repeater{}},
+			opts: []httpencoder.Option{
+				httpencoder.WithMediaTypeEncoders("text/*", map[string]httpencoder.Encoder{"quadro": repeater2{}}),
+			},
+			force: false,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			handler := httpencoder.New(iterTest.encoders, nil, iterTest.opts...)(
+				http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+					responseWriter.Header().Set("ETag", upstreamETag)
+					responseWriter.Header().Set("Content-Type", "text/plain")
+
+					if iterTest.force {
+						httpencoder.Force(responseWriter, "quadro")
+					}
+
+					if request.Header.Get("If-None-Match") == upstreamETag {
+						responseWriter.WriteHeader(http.StatusNotModified)
+
+						return
+					}
+
+					_, _ = responseWriter.Write([]byte(testString))
+				}),
+			)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", "repeate, quadro")
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("ETag") != `"v1-quadro"` {
+				t.Fatalf("invalid ETag header in response, want %s but got %s", `"v1-quadro"`, recorder.Header().Get("ETag"))
+			}
+
+			recorder = httptest.NewRecorder()
+			request.Header.Set("If-None-Match", `"v1-quadro"`)
+
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusNotModified {
+				t.Fatalf("unexpected response status code, want %d but got %d", http.StatusNotModified, recorder.Code)
+			}
+		})
+	}
+}
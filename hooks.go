@@ -0,0 +1,120 @@
+package httpencoder
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type (
+	// SkipReason describes why middleware left body as is.
+	SkipReason string
+
+	// Hooks are callbacks invoked by middleware with request context
+	// to observe encode and decode events. Any callback can be nil.
+	Hooks struct {
+		// OnNegotiated is called when response encoding is negotiated before
+		// handler call. Response can still be sent with other encoding, which
+		// is reported by OnEncoded, OnSkipped or OnDegraded afterwards.
+		OnNegotiated func(ctx context.Context, coding string)
+		// OnEncoded is called after response body is encoded.
+		OnEncoded func(ctx context.Context, coding string, inBytes, outBytes int, duration time.Duration)
+		// OnDecoded is called after request body is decoded by single decoder.
+		OnDecoded func(ctx context.Context, coding string, inBytes, outBytes int, duration time.Duration)
+		// OnTranscoded is called after response body encoded by handler with coding,
+		// not accepted by client, is decoded by single decoder, see WithTranscoding.
+		OnTranscoded func(ctx context.Context, coding string, inBytes, outBytes int, duration time.Duration)
+		// OnSkipped is called when response is not encoded or request is not decoded.
+		OnSkipped func(ctx context.Context, reason SkipReason)
+		// OnDegraded is called when encode concurrency limit is reached and
+		// response is encoded with degraded level or sent as is with identity coding.
+		OnDegraded func(ctx context.Context, coding string)
+		// OnPadded is called when sensitive response is encoded with random padding, see WithBreachMitigation.
+		OnPadded func(ctx context.Context, coding string, padding int)
+		// OnError is called when Encoder or Decoder fails, body exceeds Policy limits with ErrTooLarge
+		// or request body does not match its digest with ErrDigestMismatch.
+		OnError func(ctx context.Context, coding string, err error)
+	}
+)
+
+const (
+	// SkipNoAcceptEncoding means request has no Accept-Encoding header.
+	SkipNoAcceptEncoding SkipReason = "no_accept_encoding"
+	// SkipUpgrade means request asks for protocol upgrade.
+	SkipUpgrade SkipReason = "upgrade"
+	// SkipNoEncoder means none of registered encoders is accepted by client.
+	SkipNoEncoder SkipReason = "no_encoder"
+	// SkipDisabled means handler called Disable or Policy disables encoding or decoding.
+	SkipDisabled SkipReason = "disabled"
+	// SkipAlreadyEncoded means handler set Content-Encoding header itself.
+	SkipAlreadyEncoded SkipReason = "already_encoded"
+	// SkipNoTransform means message has Cache-Control: no-transform.
+	SkipNoTransform SkipReason = "no_transform"
+	// SkipTooSmall means response is smaller than Policy.MinSize.
+	SkipTooSmall SkipReason = "too_small"
+	// SkipNotSmaller means encoded response is not smaller enough than original, see WithMinSavings.
+	SkipNotSmaller SkipReason = "not_smaller"
+	// SkipSensitive means response is vulnerable to BREACH attack, see MarkSensitive.
+	SkipSensitive SkipReason = "sensitive"
+	// SkipUnknownEncoding means request is encoded with unregistered content coding.
+	SkipUnknownEncoding SkipReason = "unknown_encoding"
+)
+
+// WithHooks sets callbacks to observe encode and decode events.
+func WithHooks(hooks Hooks) Option {
+	return func(cfg *config) {
+		cfg.hooks = hooks
+	}
+}
+
+func serverTiming(metric, coding string, duration time.Duration) string {
+	milliseconds := strconv.FormatFloat(float64(duration.Microseconds())/float64(time.Millisecond/time.Microsecond), 'f', -1, 64)
+
+	return metric + `;desc="` + coding + `";dur=` + milliseconds
+}
+
+func (cfg *config) negotiated(ctx context.Context, coding string) {
+	if cfg.hooks.OnNegotiated != nil {
+		cfg.hooks.OnNegotiated(ctx, coding)
+	}
+}
+
+func (cfg *config) encoded(ctx context.Context, coding string, inBytes, outBytes int, duration time.Duration) {
+	if cfg.hooks.OnEncoded != nil {
+		cfg.hooks.OnEncoded(ctx, coding, inBytes, outBytes, duration)
+	}
+}
+
+func (cfg *config) decoded(ctx context.Context, coding string, inBytes, outBytes int, duration time.Duration) {
+	if cfg.hooks.OnDecoded != nil {
+		cfg.hooks.OnDecoded(ctx, coding, inBytes, outBytes, duration)
+	}
+}
+
+func (cfg *config) skipped(request *http.Request, reason SkipReason, attrs ...slog.Attr) {
+	if cfg.hooks.OnSkipped != nil {
+		cfg.hooks.OnSkipped(request.Context(), reason)
+	}
+
+	if reason == SkipUnknownEncoding {
+		cfg.log(request, cfg.logLevels.Unknown, "httpencoder: unknown content coding", attrs...)
+	} else {
+		attrs = append(attrs, slog.String("reason", string(reason)))
+		cfg.log(request, cfg.logLevels.Skipped, "httpencoder: body left as is", attrs...)
+	}
+}
+
+func (cfg *config) failed(request *http.Request, coding string, size int, err error) {
+	if cfg.hooks.OnError != nil {
+		cfg.hooks.OnError(request.Context(), coding, err)
+	}
+
+	cfg.log(request, cfg.logLevels.Failure, "httpencoder: codec failed",
+		slog.String("coding", coding),
+		slog.Int("size", size),
+		slog.Int("status", http.StatusInternalServerError),
+		slog.String("error", err.Error()),
+	)
+}
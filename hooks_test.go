@@ -0,0 +1,169 @@
+package httpencoder_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type recordedEvents struct {
+	events []string
+	mutex  sync.Mutex
+}
+
+func (recorded *recordedEvents) add(event string) {
+	recorded.mutex.Lock()
+	defer recorded.mutex.Unlock()
+
+	recorded.events = append(recorded.events, event)
+}
+
+func (recorded *recordedEvents) hooks() httpencoder.Hooks {
+	return httpencoder.Hooks{
+		OnNegotiated: func(_ context.Context, coding string) {
+			recorded.add("negotiated " + coding)
+		},
+		OnEncoded: func(_ context.Context, coding string, inBytes, outBytes int, _ time.Duration) {
+			if outBytes != 2*inBytes {
+				recorded.add("encoded with invalid sizes")
+			}
+
+			recorded.add("encoded " + coding)
+		},
+		OnDecoded: func(_ context.Context, coding string, inBytes, outBytes int, _ time.Duration) {
+			if inBytes != 2*outBytes {
+				recorded.add("decoded with invalid sizes")
+			}
+
+			recorded.add("decoded " + coding)
+		},
+		OnSkipped: func(_ context.Context, reason httpencoder.SkipReason) {
+			recorded.add("skipped " + string(reason))
+		},
+		OnError: func(_ context.Context, coding string, _ error) {
+			recorded.add("failed " + coding)
+		},
+	}
+}
+
+func TestHooks(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+
+	tests := []struct {
+		testName        string
+		contentEncoding string
+		acceptEncoding  string
+		events          string
+	}{
+		{
+			testName:        "encode and decode",
+			contentEncoding: "repeate",
+			acceptEncoding:  "repeate",
+			events:          "negotiated repeate, decoded repeate, encoded repeate",
+		}, {
+			testName:        "unknown encodings",
+			contentEncoding: "fake",
+			acceptEncoding:  "fake",
+			events:          "skipped no_encoder, skipped unknown_encoding",
+		}, {
+			testName:        "no accept encoding",
+			contentEncoding: "",
+			acceptEncoding:  "",
+			events:          "skipped no_accept_encoding",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorded := &recordedEvents{}
+			compress := httpencoder.New(encoders, decoders, httpencoder.WithHooks(recorded.hooks()))
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("aabbcc"))
+			request.Header.Set("Content-Encoding", iterTest.contentEncoding)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			compress(handlerWithoutEncoding).ServeHTTP(recorder, request)
+
+			actual := strings.Join(recorded.events, ", ")
+			if actual != iterTest.events {
+				t.Fatalf("invalid events: want '%s' but got '%s'", iterTest.events, actual)
+			}
+		})
+	}
+}
+
+func TestServerTiming(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	compress := httpencoder.New(encoders, decoders, httpencoder.WithServerTiming())
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Add("Server-Timing", "db;dur=1")
+		handlerWithoutEncoding(responseWriter, request)
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("aabbcc"))
+	request.Header.Set("Content-Encoding", "repeate")
+	request.Header.Set("Accept-Encoding", "repeate")
+
+	compress(handler).ServeHTTP(recorder, request)
+
+	response := recorder.Result()
+	defer response.Body.Close()
+
+	timings := response.Header.Values("Server-Timing")
+	if len(timings) != 3 ||
+		!strings.HasPrefix(timings[0], `dec;desc="repeate";dur=`) ||
+		timings[1] != "db;dur=1" ||
+		!strings.HasPrefix(timings[2], `enc;desc="repeate";dur=`) {
+		test.Fatalf("invalid Server-Timing header values: %v", timings)
+	}
+
+	if response.Header.Get("Content-Length") != "6" || recorder.Body.String() != "ccbbaa" {
+		test.Fatalf("invalid response: Content-Length %s, body '%s'", response.Header.Get("Content-Length"), recorder.Body)
+	}
+}
+
+func TestLogger(test *testing.T) {
+	test.Parallel()
+
+	output := &bytes.Buffer{}
+	logger := slog.New(slog.NewTextHandler(output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	compress := httpencoder.New(nil, decoders, httpencoder.WithLogger(logger, httpencoder.DefaultLogLevels()))
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/path", strings.NewReader("aabbcc"))
+	request.Header.Set("Content-Encoding", "fake")
+
+	compress(handlerWithoutEncoding).ServeHTTP(recorder, request)
+
+	for _, expected := range []string{
+		`level=DEBUG msg="httpencoder: body left as is" reason=no_accept_encoding method=POST path=/path`,
+		`level=WARN msg="httpencoder: unknown content coding" coding=fake method=POST path=/path`,
+	} {
+		if !strings.Contains(output.String(), expected) {
+			test.Fatalf("log has no '%s':\n%s", expected, output)
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"math"
 	"net/http"
+	"strings"
 	"sync"
 )
 
@@ -16,6 +18,21 @@ type (
 		// Encode encodes http.ResponseWriter body.
 		Encode(ctx context.Context, to io.Writer, from []byte) error
 	}
+	// LeveledEncoder implements Encoder with compression level chosen per call.
+	LeveledEncoder interface {
+		Encoder
+		// EncodeLevel encodes http.ResponseWriter body with provided compression level.
+		EncodeLevel(ctx context.Context, to io.Writer, from []byte, level int) error
+	}
+	// EncryptingEncoder implements Encoder of encrypted content coding, like
+	// aes128gcm. Negotiated encrypting coding is applied even if response is
+	// too small, does not get smaller or encode concurrency limit is reached,
+	// so body is never sent in plaintext instead.
+	EncryptingEncoder interface {
+		Encoder
+		// Encrypts reports whether Encoder encrypts http.ResponseWriter body.
+		Encrypts() bool
+	}
 	// Decoder implements reader for http.Request body.
 	Decoder interface {
 		// Decode decodes http.Request.Body.
@@ -23,19 +40,21 @@ type (
 	}
 )
 
+// DefaultLevel asks Encoder to use its own default compression level.
+const DefaultLevel = math.MinInt32
+
 // New returns net/http middleware for auto decode http.Request
 // and/or auto encode http.ResponseWriter body based on provided Encoders/Decoders.
-func New(encoders map[string]Encoder, decoders map[string]Decoder) func(next http.Handler) http.Handler {
-	bufferPool := &sync.Pool{
-		New: func() interface{} {
-			return &bytes.Buffer{}
-		},
-	}
+func New(encoders map[string]Encoder, decoders map[string]Decoder, opts ...Option) func(next http.Handler) http.Handler {
+	cfg := newConfig(encoders, decoders, opts)
 
 	return func(next http.Handler) http.Handler {
-		next = decode(bufferPool, decoders, next)
+		handler := encode(cfg, decode(cfg, next))
+		if cfg.grpc || cfg.connect {
+			handler = envelope(cfg, next, handler)
+		}
 
-		return encode(bufferPool, encoders, next)
+		return handler
 	}
 }
 
@@ -53,8 +72,28 @@ func bufferPut(bufferPool *sync.Pool, buffer *bytes.Buffer) {
 	bufferPool.Put(buffer)
 }
 
-func isAlpha(ch byte) bool {
-	return ch >= 'a' && ch <= 'z'
+// hasCacheDirective reports whether Cache-Control header contains directive.
+func hasCacheDirective(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, token := range strings.Split(value, ",") {
+			name := strings.TrimSpace(token)
+			if eq := strings.IndexByte(name, '='); eq >= 0 {
+				name = strings.TrimSpace(name[:eq])
+			}
+
+			if strings.EqualFold(name, directive) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isTokenChar reports whether ch is lowercased tchar of RFC 9110 token,
+// so content codings like "x-gzip" or "aes128gcm" are parsed as is.
+func isTokenChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || isDigit(ch) || strings.IndexByte("!#$%&'*+-.^_`|~", ch) >= 0
 }
 
 func compactAndLow(input []byte) []byte {
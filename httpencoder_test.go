@@ -3,6 +3,7 @@ package httpencoder_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -337,3 +338,211 @@ func reverse(str []byte) []byte {
 
 	return retVal
 }
+
+func TestNoTransform(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("Cache-Control", "max-age=60, No-Transform")
+		handlerWithoutEncoding(responseWriter, request)
+	})
+
+	tests := []struct {
+		testName       string
+		opts           []httpencoder.Option
+		requestBody    string
+		responseBody   string
+		cacheControl   string
+		contentEncoded string
+	}{
+		{
+			testName:       "response is not encoded",
+			opts:           nil,
+			requestBody:    "aabbcc",
+			responseBody:   "cba",
+			cacheControl:   "",
+			contentEncoded: "",
+		}, {
+			testName:       "request is decoded by default",
+			opts:           nil,
+			requestBody:    "aabbcc",
+			responseBody:   "cba",
+			cacheControl:   "no-transform",
+			contentEncoded: "",
+		}, {
+			testName:       "request is not decoded on demand",
+			opts:           []httpencoder.Option{httpencoder.WithRequestNoTransform()},
+			requestBody:    "aabbcc",
+			responseBody:   "ccbbaa",
+			cacheControl:   "no-transform",
+			contentEncoded: "repeate",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			var contentEncoded string
+
+			upstream := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+				contentEncoded = request.Header.Get("Content-Encoding")
+				handler(responseWriter, request)
+			})
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(iterTest.requestBody))
+			request.Header.Set("Content-Encoding", "repeate")
+			request.Header.Set("Accept-Encoding", "repeate")
+			request.Header.Set("Cache-Control", iterTest.cacheControl)
+
+			httpencoder.New(encoders, decoders, iterTest.opts...)(upstream).ServeHTTP(recorder, request)
+
+			response := recorder.Result()
+			defer response.Body.Close()
+
+			if response.Header.Get("Content-Encoding") != "" {
+				t.Fatalf("unexpected Content-Encoding header in response: %s", response.Header.Get("Content-Encoding"))
+			}
+
+			if contentEncoded != iterTest.contentEncoded {
+				t.Fatalf("invalid Content-Encoding header in request, want %s but got %s", iterTest.contentEncoded, contentEncoded)
+			}
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal("cannot Read response.Body after http request: " + err.Error())
+			}
+
+			if string(body) != iterTest.responseBody {
+				t.Fatalf("invalid response: want '%s' but got '%s'", iterTest.responseBody, body)
+			}
+		})
+	}
+}
+
+func TestContextEncodings(test *testing.T) {
+	test.Parallel()
+
+	var (
+		responseEncoding string
+		decodedEncodings []string
+	)
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseEncoding = httpencoder.ResponseEncoding(request.Context())
+		decodedEncodings = httpencoder.DecodedEncodings(request.Context())
+
+		handlerWithoutEncoding(responseWriter, request)
+	})
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("aaaabbbb"))
+	request.Header.Set("Content-Encoding", "repeate, repeate")
+	request.Header.Set("Accept-Encoding", "repeate")
+
+	httpencoder.New(encoders, decoders)(handler).ServeHTTP(recorder, request)
+
+	if responseEncoding != "repeate" {
+		test.Fatalf("invalid response encoding in context, want repeate but got %s", responseEncoding)
+	}
+
+	if strings.Join(decodedEncodings, ",") != "repeate,repeate" {
+		test.Fatalf("invalid decoded encodings in context, want [repeate repeate] but got %v", decodedEncodings)
+	}
+}
+
+func TestNegotiatedEncodingIsStable(test *testing.T) {
+	test.Parallel()
+
+	var ctx context.Context
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		ctx = request.Context()
+
+		httpencoder.Disable(responseWriter)
+		handlerWithoutEncoding(responseWriter, request)
+	})
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(testString))
+	request.Header.Set("Accept-Encoding", "repeate")
+
+	httpencoder.New(encoders, nil)(handler).ServeHTTP(recorder, request)
+
+	if recorder.Header().Get("Content-Encoding") != "" {
+		test.Fatalf("invalid Content-Encoding header in response, want empty but got %s",
+			recorder.Header().Get("Content-Encoding"))
+	}
+
+	// context outlives handler, so negotiated encoding must not be rewritten afterwards
+	if httpencoder.ResponseEncoding(ctx) != "repeate" {
+		test.Fatalf("invalid response encoding in context, want repeate but got %s", httpencoder.ResponseEncoding(ctx))
+	}
+}
+
+func TestDecodedContentLength(test *testing.T) {
+	test.Parallel()
+
+	var contentLength int64
+
+	handler := http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		contentLength = request.ContentLength
+	})
+
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("tteesstt"))
+	request.Header.Set("Content-Encoding", "repeate")
+
+	httpencoder.New(nil, decoders)(handler).ServeHTTP(httptest.NewRecorder(), request)
+
+	if contentLength != int64(len("test")) {
+		test.Fatalf("invalid ContentLength of decoded request, want %d but got %d", len("test"), contentLength)
+	}
+}
+
+func TestFlushNotSupported(test *testing.T) {
+	test.Parallel()
+
+	var flushErr error
+
+	handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+		responseWriter.WriteHeader(http.StatusNotFound)
+
+		_, _ = responseWriter.Write([]byte(testString))
+
+		flushErr = http.NewResponseController(responseWriter).Flush()
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Accept-Encoding", "repeate")
+
+	httpencoder.New(map[string]httpencoder.Encoder{"repeate": repeater{}}, nil)(handler).ServeHTTP(recorder, request)
+
+	if !errors.Is(flushErr, http.ErrNotSupported) {
+		test.Fatalf("buffered response must not be flushed, want %v but got %v", http.ErrNotSupported, flushErr)
+	}
+
+	if recorder.Code != http.StatusNotFound {
+		test.Fatalf("unexpected response status code, want %d but got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	if recorder.Header().Get("Content-Encoding") != "repeate" {
+		test.Fatalf("invalid Content-Encoding header in response: %s", recorder.Header().Get("Content-Encoding"))
+	}
+
+	if recorder.Body.String() != "tteesstt  ssttrriinngg" {
+		test.Fatalf("invalid response body: %s", recorder.Body.String())
+	}
+}
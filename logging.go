@@ -0,0 +1,52 @@
+package httpencoder
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// LogLevels are levels of events logged by middleware with WithLogger.
+type LogLevels struct {
+	// Failure is level of Encoder and Decoder failures.
+	Failure slog.Level
+	// Limit is level of Policy body size limit violations.
+	Limit slog.Level
+	// Unknown is level of requests encoded with unregistered content coding.
+	Unknown slog.Level
+	// Skipped is level of bodies left as is, like not negotiated responses.
+	Skipped slog.Level
+	// Degraded is level of responses degraded by encode concurrency limit.
+	Degraded slog.Level
+}
+
+// DefaultLogLevels returns LogLevels with failures logged as errors,
+// limit violations and unknown codings as warnings, degraded responses as info and skipped
+// bodies as debug messages.
+func DefaultLogLevels() LogLevels {
+	return LogLevels{
+		Failure:  slog.LevelError,
+		Limit:    slog.LevelWarn,
+		Unknown:  slog.LevelWarn,
+		Skipped:  slog.LevelDebug,
+		Degraded: slog.LevelInfo,
+	}
+}
+
+// WithLogger makes middleware log its events to logger with provided levels.
+// Without it middleware logs nothing.
+func WithLogger(logger *slog.Logger, levels LogLevels) Option {
+	return func(cfg *config) {
+		cfg.logger = logger
+		cfg.logLevels = levels
+	}
+}
+
+func (cfg *config) log(request *http.Request, level slog.Level, msg string, attrs ...slog.Attr) {
+	if cfg.logger == nil || !cfg.logger.Enabled(request.Context(), level) {
+		return
+	}
+
+	attrs = append(attrs, slog.String("method", request.Method), slog.String("path", request.URL.Path))
+
+	cfg.logger.LogAttrs(request.Context(), level, msg, attrs...)
+}
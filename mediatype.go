@@ -0,0 +1,128 @@
+package httpencoder
+
+import "strings"
+
+type mediaTypeEncoders struct {
+	encoders   map[string]Encoder
+	pattern    string
+	preference []string
+}
+
+// Specificity of media type pattern match, more specific pattern wins.
+const (
+	noMatch = iota - 1
+	anyMatch
+	subtypeWildcardMatch
+	exactMatch
+)
+
+// WithMediaTypeEncoders makes middleware encode responses which Content-Type
+// matches pattern, like "application/json", "text/*" or "*/*", with provided
+// encoders instead of ones passed to New. The most specific pattern wins.
+// Preference lists server preferred codings to choose between codings with
+// equal quality values in Accept-Encoding header, otherwise header order wins.
+func WithMediaTypeEncoders(pattern string, encoders map[string]Encoder, preference ...string) Option {
+	return func(cfg *config) {
+		cfg.mediaTypes = append(cfg.mediaTypes, mediaTypeEncoders{
+			encoders:   encoders,
+			pattern:    strings.ToLower(strings.TrimSpace(pattern)),
+			preference: preference,
+		})
+	}
+}
+
+// negotiableEncoders returns encoders which could be used for any response.
+func (cfg *config) negotiableEncoders() map[string]Encoder {
+	if len(cfg.mediaTypes) == 0 {
+		return cfg.encoders
+	}
+
+	negotiable := make(map[string]Encoder, len(cfg.encoders))
+
+	for encodingType, encoder := range cfg.encoders {
+		negotiable[encodingType] = encoder
+	}
+
+	for _, mediaType := range cfg.mediaTypes {
+		for encodingType, encoder := range mediaType.encoders {
+			negotiable[encodingType] = encoder
+		}
+	}
+
+	return negotiable
+}
+
+// encodersFor returns encoders and server preference for response Content-Type.
+func (cfg *config) encodersFor(contentType string) (map[string]Encoder, []string) {
+	mediaType := contentType
+	if end := strings.IndexByte(mediaType, ';'); end >= 0 {
+		mediaType = mediaType[:end]
+	}
+
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	bestMatch, bestSpecificity := -1, noMatch
+
+	for i, candidate := range cfg.mediaTypes {
+		if specificity := matchMediaType(candidate.pattern, mediaType); specificity > bestSpecificity {
+			bestMatch, bestSpecificity = i, specificity
+		}
+	}
+
+	if bestMatch < 0 {
+		return cfg.encoders, nil
+	}
+
+	return cfg.mediaTypes[bestMatch].encoders, cfg.mediaTypes[bestMatch].preference
+}
+
+func matchMediaType(pattern, mediaType string) int {
+	switch {
+	case pattern == mediaType:
+		return exactMatch
+	case pattern == "*/*" || pattern == "*":
+		return anyMatch
+	case strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, pattern[:len(pattern)-1]):
+		return subtypeWildcardMatch
+	default:
+		return noMatch
+	}
+}
+
+// getServerPreferedEncoder negotiates encoding like getPreferedEncoder,
+// but codings with equal quality values are ordered by server preference.
+//
+//nolint:ireturn // helper function
+func getServerPreferedEncoder(
+	acceptEncodingHeader []byte, encoders map[string]Encoder, preference []string,
+) (Encoder, string) {
+	acceptable := acceptablePreferences(acceptEncodingHeader, encoders)
+	if len(acceptable) == 0 {
+		return nil, ""
+	}
+
+	preferedEncodingType := acceptable[0].Coding
+	preferedRank := preferenceRank(preference, preferedEncodingType)
+
+	for _, candidate := range acceptable[1:] {
+		if candidate.Quality < acceptable[0].Quality {
+			break
+		}
+
+		if rank := preferenceRank(preference, candidate.Coding); rank < preferedRank {
+			preferedEncodingType, preferedRank = candidate.Coding, rank
+		}
+	}
+
+	return encoders[preferedEncodingType], preferedEncodingType
+}
+
+func preferenceRank(preference []string, encodingType string) int {
+	for rank, candidate := range preference {
+		if strings.EqualFold(candidate, encodingType) {
+			return rank
+		}
+	}
+
+	return len(preference)
+}
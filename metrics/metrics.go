@@ -0,0 +1,369 @@
+// Package metrics provides httpencoder.Hooks implementation which aggregates
+// per coding counters and histograms and exposes them via expvar and as
+// Prometheus text format http.Handler.
+package metrics
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type (
+	// Collector aggregates encode and decode events. Zero value is not usable, use New.
+	Collector struct {
+		encoded      map[string]*codingStats
+		decoded      map[string]*codingStats
+		transcoded   map[string]*codingStats
+		skipped      map[httpencoder.SkipReason]uint64
+		degraded     map[string]uint64
+		padded       map[string]uint64
+		paddingBytes map[string]uint64
+		errors       map[string]uint64
+		mutex        sync.Mutex
+	}
+
+	codingStats struct {
+		durations *histogram
+		ratios    *histogram
+		count     uint64
+		bytesIn   uint64
+		bytesOut  uint64
+	}
+
+	histogram struct {
+		bounds []float64
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+
+	// Snapshot is point in time copy of collected metrics, published via expvar.
+	Snapshot struct {
+		Encoded      map[string]CodingSnapshot `json:"encoded"`
+		Decoded      map[string]CodingSnapshot `json:"decoded"`
+		Transcoded   map[string]CodingSnapshot `json:"transcoded"`
+		Skipped      map[string]uint64         `json:"skipped"`
+		Degraded     map[string]uint64         `json:"degraded"`
+		Padded       map[string]uint64         `json:"padded"`
+		PaddingBytes map[string]uint64         `json:"paddingBytes"`
+		Errors       map[string]uint64         `json:"errors"`
+	}
+
+	// CodingSnapshot is point in time copy of single coding metrics.
+	CodingSnapshot struct {
+		Count           uint64  `json:"count"`
+		BytesIn         uint64  `json:"bytesIn"`
+		BytesOut        uint64  `json:"bytesOut"`
+		DurationSeconds float64 `json:"durationSeconds"`
+	}
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// New returns empty Collector.
+func New() *Collector {
+	return &Collector{
+		encoded:      map[string]*codingStats{},
+		decoded:      map[string]*codingStats{},
+		transcoded:   map[string]*codingStats{},
+		skipped:      map[httpencoder.SkipReason]uint64{},
+		degraded:     map[string]uint64{},
+		padded:       map[string]uint64{},
+		paddingBytes: map[string]uint64{},
+		errors:       map[string]uint64{},
+		mutex:        sync.Mutex{},
+	}
+}
+
+// Hooks returns httpencoder.Hooks which feed Collector.
+func (collector *Collector) Hooks() httpencoder.Hooks {
+	return httpencoder.Hooks{
+		OnNegotiated: nil,
+		OnEncoded: func(_ context.Context, coding string, inBytes, outBytes int, duration time.Duration) {
+			collector.observe(collector.encoded, coding, inBytes, outBytes, duration)
+		},
+		OnDecoded: func(_ context.Context, coding string, inBytes, outBytes int, duration time.Duration) {
+			collector.observe(collector.decoded, coding, inBytes, outBytes, duration)
+		},
+		OnTranscoded: func(_ context.Context, coding string, inBytes, outBytes int, duration time.Duration) {
+			collector.observe(collector.transcoded, coding, inBytes, outBytes, duration)
+		},
+		OnSkipped: func(_ context.Context, reason httpencoder.SkipReason) {
+			collector.mutex.Lock()
+			collector.skipped[reason]++
+			collector.mutex.Unlock()
+		},
+		OnDegraded: func(_ context.Context, coding string) {
+			collector.mutex.Lock()
+			collector.degraded[coding]++
+			collector.mutex.Unlock()
+		},
+		OnPadded: func(_ context.Context, coding string, padding int) {
+			collector.mutex.Lock()
+			collector.padded[coding]++
+			collector.paddingBytes[coding] += uint64(padding)
+			collector.mutex.Unlock()
+		},
+		OnError: func(_ context.Context, coding string, _ error) {
+			collector.mutex.Lock()
+			collector.errors[coding]++
+			collector.mutex.Unlock()
+		},
+	}
+}
+
+// Publish exposes Collector's Snapshot via expvar with provided name.
+// As expvar.Publish it panics if name is already registered.
+func (collector *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return collector.Snapshot()
+	}))
+}
+
+// Snapshot returns point in time copy of collected metrics.
+func (collector *Collector) Snapshot() Snapshot {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	snapshot := Snapshot{
+		Encoded:      make(map[string]CodingSnapshot, len(collector.encoded)),
+		Decoded:      make(map[string]CodingSnapshot, len(collector.decoded)),
+		Transcoded:   make(map[string]CodingSnapshot, len(collector.transcoded)),
+		Skipped:      make(map[string]uint64, len(collector.skipped)),
+		Degraded:     make(map[string]uint64, len(collector.degraded)),
+		Padded:       make(map[string]uint64, len(collector.padded)),
+		PaddingBytes: make(map[string]uint64, len(collector.paddingBytes)),
+		Errors:       make(map[string]uint64, len(collector.errors)),
+	}
+
+	for coding, stats := range collector.encoded {
+		snapshot.Encoded[coding] = stats.snapshot()
+	}
+
+	for coding, stats := range collector.decoded {
+		snapshot.Decoded[coding] = stats.snapshot()
+	}
+
+	for coding, stats := range collector.transcoded {
+		snapshot.Transcoded[coding] = stats.snapshot()
+	}
+
+	for reason, count := range collector.skipped {
+		snapshot.Skipped[string(reason)] = count
+	}
+
+	for coding, count := range collector.degraded {
+		snapshot.Degraded[coding] = count
+	}
+
+	for coding, count := range collector.padded {
+		snapshot.Padded[coding] = count
+	}
+
+	for coding, count := range collector.paddingBytes {
+		snapshot.PaddingBytes[coding] = count
+	}
+
+	for coding, count := range collector.errors {
+		snapshot.Errors[coding] = count
+	}
+
+	return snapshot
+}
+
+// ServeHTTP writes collected metrics in Prometheus text exposition format.
+func (collector *Collector) ServeHTTP(responseWriter http.ResponseWriter, _ *http.Request) {
+	builder := &strings.Builder{}
+
+	collector.mutex.Lock()
+	writeCodingStats(builder, "encode", collector.encoded)
+	writeCodingStats(builder, "decode", collector.decoded)
+	writeCodingStats(builder, "transcode", collector.transcoded)
+	writeCounters(builder, "httpencoder_skipped_total",
+		"Bodies left as is by reason.", "reason", skippedByString(collector.skipped))
+	writeCounters(builder, "httpencoder_degraded_total",
+		"Responses degraded by encode concurrency limit by used coding.", "coding", collector.degraded)
+	writeCounters(builder, "httpencoder_padded_total",
+		"Sensitive responses encoded with random padding by coding.", "coding", collector.padded)
+	writeCounters(builder, "httpencoder_padding_bytes_total",
+		"Random padding bytes added to sensitive responses by coding.", "coding", collector.paddingBytes)
+	writeCounters(builder, "httpencoder_errors_total",
+		"Encoder and Decoder failures by coding.", "coding", collector.errors)
+	collector.mutex.Unlock()
+
+	responseWriter.Header().Set("Content-Type", contentType)
+
+	_, err := io.WriteString(responseWriter, builder.String())
+	if err != nil {
+		http.Error(responseWriter, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (collector *Collector) observe(
+	stats map[string]*codingStats, coding string, inBytes, outBytes int, duration time.Duration,
+) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+
+	codingStat, exist := stats[coding]
+	if !exist {
+		codingStat = &codingStats{
+			durations: newHistogram(durationBounds()),
+			ratios:    newHistogram(ratioBounds()),
+			count:     0,
+			bytesIn:   0,
+			bytesOut:  0,
+		}
+		stats[coding] = codingStat
+	}
+
+	codingStat.count++
+	codingStat.bytesIn += uint64(inBytes)
+	codingStat.bytesOut += uint64(outBytes)
+	codingStat.durations.observe(duration.Seconds())
+
+	if inBytes > 0 {
+		codingStat.ratios.observe(float64(outBytes) / float64(inBytes))
+	}
+}
+
+func (stats *codingStats) snapshot() CodingSnapshot {
+	return CodingSnapshot{
+		Count:           stats.count,
+		BytesIn:         stats.bytesIn,
+		BytesOut:        stats.bytesOut,
+		DurationSeconds: stats.durations.sum,
+	}
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+		sum:    0,
+		count:  0,
+	}
+}
+
+func (hist *histogram) observe(value float64) {
+	for i, bound := range hist.bounds {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+
+	hist.sum += value
+	hist.count++
+}
+
+// durationBounds returns histogram buckets for encode/decode durations in seconds.
+func durationBounds() []float64 {
+	return []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+}
+
+// ratioBounds returns histogram buckets for output to input size ratio.
+func ratioBounds() []float64 {
+	return []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1, 1.5}
+}
+
+func writeCodingStats(builder *strings.Builder, operation string, stats map[string]*codingStats) {
+	prefix := "httpencoder_" + operation
+
+	counts := make(map[string]uint64, len(stats))
+	bytesIn := make(map[string]uint64, len(stats))
+	bytesOut := make(map[string]uint64, len(stats))
+
+	for coding, codingStat := range stats {
+		counts[coding] = codingStat.count
+		bytesIn[coding] = codingStat.bytesIn
+		bytesOut[coding] = codingStat.bytesOut
+	}
+
+	writeCounters(builder, prefix+"_total", "Bodies processed by coding.", "coding", counts)
+	writeCounters(builder, prefix+"_bytes_in_total", "Bytes before "+operation+" by coding.", "coding", bytesIn)
+	writeCounters(builder, prefix+"_bytes_out_total", "Bytes after "+operation+" by coding.", "coding", bytesOut)
+
+	writeHistograms(builder, prefix+"_duration_seconds", "Time spent to "+operation+" body.", stats,
+		func(codingStat *codingStats) *histogram { return codingStat.durations })
+	writeHistograms(builder, prefix+"_ratio", "Output to input size ratio.", stats,
+		func(codingStat *codingStats) *histogram { return codingStat.ratios })
+}
+
+func writeCounters(builder *strings.Builder, name, help, label string, values map[string]uint64) {
+	if len(values) == 0 {
+		return
+	}
+
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+
+	for _, key := range sortedKeys(values) {
+		fmt.Fprintf(builder, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(key), values[key])
+	}
+}
+
+func writeHistograms(
+	builder *strings.Builder, name, help string, stats map[string]*codingStats, get func(*codingStats) *histogram,
+) {
+	if len(stats) == 0 {
+		return
+	}
+
+	fmt.Fprintf(builder, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	codings := make([]string, 0, len(stats))
+	for coding := range stats {
+		codings = append(codings, coding)
+	}
+
+	sort.Strings(codings)
+
+	for _, coding := range codings {
+		hist := get(stats[coding])
+		label := escapeLabel(coding)
+
+		for i, bound := range hist.bounds {
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			fmt.Fprintf(builder, "%s_bucket{coding=\"%s\",le=\"%s\"} %d\n", name, label, le, hist.counts[i])
+		}
+
+		fmt.Fprintf(builder, "%s_bucket{coding=\"%s\",le=\"+Inf\"} %d\n", name, label, hist.count)
+		fmt.Fprintf(builder, "%s_sum{coding=\"%s\"} %s\n", name, label, strconv.FormatFloat(hist.sum, 'g', -1, 64))
+		fmt.Fprintf(builder, "%s_count{coding=\"%s\"} %d\n", name, label, hist.count)
+	}
+}
+
+func skippedByString(skipped map[httpencoder.SkipReason]uint64) map[string]uint64 {
+	values := make(map[string]uint64, len(skipped))
+
+	for reason, count := range skipped {
+		values[string(reason)] = count
+	}
+
+	return values
+}
+
+func sortedKeys(values map[string]uint64) []string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,131 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexdyukov/httpencoder"
+	"github.com/alexdyukov/httpencoder/metrics"
+)
+
+type identity struct{}
+
+func (identity) Encode(_ context.Context, to io.Writer, from []byte) error {
+	_, err := to.Write(from)
+
+	return err
+}
+
+func (identity) Decode(_ context.Context, to io.Writer, from []byte) error {
+	_, err := to.Write(from)
+
+	return err
+}
+
+func TestCollector(test *testing.T) {
+	test.Parallel()
+
+	collector := metrics.New()
+	compress := httpencoder.New(
+		map[string]httpencoder.Encoder{"identical": identity{}},
+		map[string]httpencoder.Decoder{"identical": identity{}},
+		httpencoder.WithHooks(collector.Hooks()),
+	)
+
+	handler := compress(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		_, _ = io.Copy(responseWriter, request.Body)
+	}))
+
+	for _, acceptEncoding := range []string{"identical", "", "fake"} {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body"))
+		request.Header.Set("Content-Encoding", "identical")
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	snapshot := collector.Snapshot()
+	if snapshot.Encoded["identical"].Count != 1 || snapshot.Decoded["identical"].BytesOut != 12 {
+		test.Fatalf("invalid snapshot: %+v", snapshot)
+	}
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	exposition := recorder.Body.String()
+
+	for _, line := range []string{
+		`httpencoder_encode_total{coding="identical"} 1`,
+		`httpencoder_decode_bytes_in_total{coding="identical"} 12`,
+		`httpencoder_encode_ratio_bucket{coding="identical",le="1"} 1`,
+		`httpencoder_encode_duration_seconds_count{coding="identical"} 1`,
+		`httpencoder_skipped_total{reason="no_accept_encoding"} 1`,
+		`httpencoder_skipped_total{reason="no_encoder"} 1`,
+	} {
+		if !strings.Contains(exposition, line+"\n") {
+			test.Fatalf("metrics exposition has no line '%s':\n%s", line, exposition)
+		}
+	}
+}
+
+func TestTranscoded(test *testing.T) {
+	test.Parallel()
+
+	collector := metrics.New()
+	hooks := collector.Hooks()
+
+	hooks.OnTranscoded(context.Background(), "identical", 4, 8, time.Millisecond)
+
+	snapshot := collector.Snapshot()
+	if snapshot.Transcoded["identical"].BytesOut != 8 {
+		test.Fatalf("invalid snapshot: %+v", snapshot)
+	}
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	exposition := recorder.Body.String()
+
+	for _, line := range []string{
+		`httpencoder_transcode_total{coding="identical"} 1`,
+		`httpencoder_transcode_bytes_out_total{coding="identical"} 8`,
+	} {
+		if !strings.Contains(exposition, line+"\n") {
+			test.Fatalf("metrics exposition has no line '%s':\n%s", line, exposition)
+		}
+	}
+}
+
+func TestPadded(test *testing.T) {
+	test.Parallel()
+
+	collector := metrics.New()
+	hooks := collector.Hooks()
+
+	hooks.OnPadded(context.Background(), "aes128gcm", 16)
+	hooks.OnPadded(context.Background(), "aes128gcm", 4)
+
+	snapshot := collector.Snapshot()
+	if snapshot.Padded["aes128gcm"] != 2 || snapshot.PaddingBytes["aes128gcm"] != 20 {
+		test.Fatalf("invalid snapshot: %+v", snapshot)
+	}
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	exposition := recorder.Body.String()
+
+	for _, line := range []string{
+		`httpencoder_padded_total{coding="aes128gcm"} 2`,
+		`httpencoder_padding_bytes_total{coding="aes128gcm"} 20`,
+	} {
+		if !strings.Contains(exposition, line+"\n") {
+			test.Fatalf("metrics exposition has no line '%s':\n%s", line, exposition)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package httpencoder
+
+import "sort"
+
+// Preference is content coding from Accept-Encoding header with its quality value.
+type Preference struct {
+	Coding  string
+	Quality float64
+}
+
+// ParseAcceptEncoding parses Accept-Encoding header into list of
+// preferences in header order, the same way middleware does.
+func ParseAcceptEncoding(header string) []Preference {
+	return parseAcceptEncoding(compactAndLow([]byte(header)))
+}
+
+func parseAcceptEncoding(acceptEncodingHeader []byte) []Preference {
+	var (
+		preferences  []Preference
+		encodingType string
+		qualityValue int
+	)
+
+	for pos := 0; pos < len(acceptEncodingHeader); pos++ {
+		encodingType, pos = getNextAcceptEncodingType(acceptEncodingHeader, pos)
+		qualityValue, pos = getNextQualityValue(acceptEncodingHeader, pos)
+
+		if encodingType == "" {
+			continue
+		}
+
+		preferences = append(preferences, Preference{
+			Coding:  encodingType,
+			Quality: float64(qualityValue) / defaultQuality,
+		})
+	}
+
+	return preferences
+}
+
+// acceptablePreferences returns registered codings accepted by client
+// ordered by quality value, with equal ones in header order.
+func acceptablePreferences(acceptEncodingHeader []byte, encoders map[string]Encoder) []Preference {
+	preferences := parseAcceptEncoding(acceptEncodingHeader)
+	acceptable := preferences[:0]
+
+	for _, preference := range preferences {
+		if _, exist := encoders[preference.Coding]; exist && preference.Quality > 0 {
+			acceptable = append(acceptable, preference)
+		}
+	}
+
+	sort.SliceStable(acceptable, func(i, j int) bool {
+		return acceptable[i].Quality > acceptable[j].Quality
+	})
+
+	return acceptable
+}
+
+// Negotiate returns content coding from available ones with the highest
+// quality value in Accept-Encoding header, the same way middleware does.
+// Codings with equal quality value are preferred in header order.
+func Negotiate(header string, available []string) (string, bool) {
+	var (
+		preferedEncodingType string
+		preferedQuality      float64
+	)
+
+	for _, preference := range ParseAcceptEncoding(header) {
+		if preference.Quality <= preferedQuality {
+			continue
+		}
+
+		for _, encodingType := range available {
+			if string(compactAndLow([]byte(encodingType))) == preference.Coding {
+				preferedEncodingType = encodingType
+				preferedQuality = preference.Quality
+
+				break
+			}
+		}
+	}
+
+	return preferedEncodingType, preferedQuality > 0
+}
+
+// ParseContentEncoding parses Content-Encoding header into list of
+// content codings in the order they were applied.
+func ParseContentEncoding(header string) []string {
+	return parseContentEncoding(compactAndLow([]byte(header)))
+}
+
+func parseContentEncoding(contentEncodingHeader []byte) []string {
+	var codings []string
+
+	for iter := 0; iter < len(contentEncodingHeader); iter++ {
+		start := iter
+
+		for iter < len(contentEncodingHeader) && isTokenChar(contentEncodingHeader[iter]) {
+			iter++
+		}
+
+		if start < iter {
+			codings = append(codings, string(contentEncodingHeader[start:iter]))
+		}
+	}
+
+	return codings
+}
@@ -0,0 +1,97 @@
+package httpencoder_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func TestParseAcceptEncoding(test *testing.T) {
+	test.Parallel()
+
+	actual := httpencoder.ParseAcceptEncoding("Gzip, br;q=0.8 , deflate;q=0")
+	expected := []httpencoder.Preference{
+		{Coding: "gzip", Quality: 1},
+		{Coding: "br", Quality: 0.8},
+		{Coding: "deflate", Quality: 0},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		test.Fatalf("invalid preferences: want %v but got %v", expected, actual)
+	}
+}
+
+func TestNegotiate(test *testing.T) {
+	test.Parallel()
+
+	tests := []struct {
+		testName  string
+		header    string
+		available []string
+		expected  string
+		found     bool
+	}{
+		{
+			testName:  "highest quality wins",
+			header:    "gzip;q=0.5, br;q=0.9",
+			available: []string{"gzip", "br"},
+			expected:  "br",
+			found:     true,
+		}, {
+			testName:  "header order wins on equal quality",
+			header:    "gzip, br",
+			available: []string{"br", "gzip"},
+			expected:  "gzip",
+			found:     true,
+		}, {
+			testName:  "zero quality is not acceptable",
+			header:    "gzip;q=0",
+			available: []string{"gzip"},
+			expected:  "",
+			found:     false,
+		}, {
+			testName:  "zero quality does not swallow next coding",
+			header:    "gzip;q=0,br",
+			available: []string{"gzip", "br"},
+			expected:  "br",
+			found:     true,
+		}, {
+			testName:  "codings with digits",
+			header:    "x-gzip;q=1, aes128gcm;q=0.5",
+			available: []string{"aes128gcm"},
+			expected:  "aes128gcm",
+			found:     true,
+		}, {
+			testName:  "nothing available",
+			header:    "gzip",
+			available: []string{"br"},
+			expected:  "",
+			found:     false,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			actual, found := httpencoder.Negotiate(iterTest.header, iterTest.available)
+			if actual != iterTest.expected || found != iterTest.found {
+				t.Fatalf("want (%s, %v) but got (%s, %v)", iterTest.expected, iterTest.found, actual, found)
+			}
+		})
+	}
+}
+
+func TestParseContentEncoding(test *testing.T) {
+	test.Parallel()
+
+	actual := httpencoder.ParseContentEncoding(" Gzip,br ")
+	expected := []string{"gzip", "br"}
+
+	if !reflect.DeepEqual(actual, expected) {
+		test.Fatalf("invalid codings: want %v but got %v", expected, actual)
+	}
+}
@@ -0,0 +1,111 @@
+package httpencoder
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	// Option configures middleware returned by New.
+	Option func(*config)
+
+	config struct {
+		bufferPool *sync.Pool
+		encoders   map[string]Encoder
+		decoders   map[string]Decoder
+		negotiable map[string]Encoder
+		mediaTypes []mediaTypeEncoders
+
+		hooks     Hooks
+		logger    *slog.Logger
+		logLevels LogLevels
+
+		encodeSemaphore chan struct{}
+		degradedLevel   int
+		adaptiveLevels  map[string]*levelController
+		selector        Selector
+		policy          func(*http.Request) Policy
+		dictionaries    *DictionaryStore
+		stackedEncoder  Encoder
+		stackedCoding   string
+		digests         []string
+		breach          BreachMitigation
+
+		minSavings float64
+
+		requestNoTransform bool
+		serverTiming       bool
+		checkSavings       bool
+		transcode          bool
+		reencode           bool
+		proxy              bool
+		grpc               bool
+		connect            bool
+	}
+)
+
+// WithRequestNoTransform makes middleware leave request body untouched if
+// request has Cache-Control: no-transform, as proxy-style intermediary should.
+func WithRequestNoTransform() Option {
+	return func(cfg *config) {
+		cfg.requestNoTransform = true
+	}
+}
+
+// WithServerTiming makes middleware report time spent in Encoder and Decoder
+// with Server-Timing response header entries "enc" and "dec". Encoded body is
+// buffered to set header before http.ResponseWriter.WriteHeader call, so
+// handlers should use Header().Add to set own Server-Timing entries.
+func WithServerTiming() Option {
+	return func(cfg *config) {
+		cfg.serverTiming = true
+	}
+}
+
+// WithMinSavings makes middleware encode response into scratch buffer and
+// send original body as is, if encoded one is not smaller by at least
+// provided fraction of original size, e.g. 0.1 for 10%. Zero fraction
+// means encoded body only has to be smaller.
+func WithMinSavings(fraction float64) Option {
+	return func(cfg *config) {
+		cfg.checkSavings = true
+		cfg.minSavings = fraction
+	}
+}
+
+// WithStackedEncoder makes middleware apply encoder on top of negotiated
+// response encoding, like encryption after compression, if client accepts
+// coding. Content-Encoding then lists both codings in the order they were
+// applied, e.g. "gzip, aes128gcm". Register coding in encoders passed to New
+// too, to use it for clients which accept it alone. If compression is skipped,
+// e.g. response is too small or does not get smaller, encoder is applied alone.
+func WithStackedEncoder(coding string, encoder Encoder) Option {
+	return func(cfg *config) {
+		cfg.stackedCoding = strings.ToLower(strings.TrimSpace(coding))
+		cfg.stackedEncoder = encoder
+	}
+}
+
+func newConfig(encoders map[string]Encoder, decoders map[string]Decoder, opts []Option) *config {
+	cfg := &config{
+		bufferPool: &sync.Pool{
+			New: func() interface{} {
+				return &bytes.Buffer{}
+			},
+		},
+		encoders:      encoders,
+		decoders:      decoders,
+		degradedLevel: DefaultLevel,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	cfg.negotiable = cfg.negotiableEncoders()
+
+	return cfg
+}
@@ -0,0 +1,65 @@
+package httpencoder
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Disable turns off encoding of current response. It must be called by
+// handler with http.ResponseWriter passed by middleware, or its wrapper
+// which implements Unwrap() http.ResponseWriter. Disable reports whether
+// middleware's http.ResponseWriter was found.
+func Disable(responseWriter http.ResponseWriter) bool {
+	wrapped := findWrappedWriter(responseWriter)
+	if wrapped == nil {
+		return false
+	}
+
+	wrapped.disabled = true
+
+	return true
+}
+
+// Force makes middleware encode current response with provided encoding
+// instead of negotiated one, if it is registered and accepted by client.
+// Force reports whether middleware's http.ResponseWriter was found.
+func Force(responseWriter http.ResponseWriter, encodingType string) bool {
+	wrapped := findWrappedWriter(responseWriter)
+	if wrapped == nil {
+		return false
+	}
+
+	wrapped.forcedEncoding = strings.ToLower(strings.TrimSpace(encodingType))
+
+	return true
+}
+
+// SetLevel sets compression level of current response for LeveledEncoder.
+// SetLevel reports whether middleware's http.ResponseWriter was found.
+func SetLevel(responseWriter http.ResponseWriter, level int) bool {
+	wrapped := findWrappedWriter(responseWriter)
+	if wrapped == nil {
+		return false
+	}
+
+	wrapped.level = level
+
+	return true
+}
+
+func findWrappedWriter(responseWriter http.ResponseWriter) *wrappedWriter {
+	for responseWriter != nil {
+		if wrapped, okay := responseWriter.(*wrappedWriter); okay {
+			return wrapped
+		}
+
+		unwrapper, okay := responseWriter.(interface{ Unwrap() http.ResponseWriter })
+		if !okay {
+			return nil
+		}
+
+		responseWriter = unwrapper.Unwrap()
+	}
+
+	return nil
+}
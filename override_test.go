@@ -0,0 +1,204 @@
+package httpencoder_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+type (
+	leveler       struct{}
+	unwrapsWriter struct {
+		http.ResponseWriter
+	}
+)
+
+func (leveler) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return leveler{}.EncodeLevel(ctx, to, from, 0)
+}
+
+func (leveler) EncodeLevel(_ context.Context, to io.Writer, from []byte, level int) error {
+	_, err := io.WriteString(to, strconv.Itoa(level)+":"+string(from))
+
+	return err
+}
+
+func (responseWriter unwrapsWriter) Unwrap() http.ResponseWriter {
+	return responseWriter.ResponseWriter
+}
+
+func TestOverride(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{
+		"repeate": repeater{},
+		"quadro":  repeater2{},
+		"level":   leveler{},
+	}
+
+	tests := []struct {
+		override        func(responseWriter http.ResponseWriter) bool
+		testName        string
+		acceptEncoding  string
+		contentEncoding string
+		responseBody    string
+	}{
+		{
+			testName:        "disable through wrapper",
+			override:        httpencoder.Disable,
+			acceptEncoding:  "repeate",
+			contentEncoding: "",
+			responseBody:    testString,
+		}, {
+			testName: "force accepted encoding",
+			override: func(responseWriter http.ResponseWriter) bool {
+				return httpencoder.Force(responseWriter, "Quadro")
+			},
+			acceptEncoding:  "repeate, quadro;q=0.5",
+			contentEncoding: "quadro",
+			responseBody:    "tttteeeesssstttt    ssssttttrrrriiiinnnngggg",
+		}, {
+			testName: "force not accepted encoding",
+			override: func(responseWriter http.ResponseWriter) bool {
+				return httpencoder.Force(responseWriter, "quadro")
+			},
+			acceptEncoding:  "repeate, quadro;q=0",
+			contentEncoding: "repeate",
+			responseBody:    "tteesstt  ssttrriinngg",
+		}, {
+			testName: "set level",
+			override: func(responseWriter http.ResponseWriter) bool {
+				return httpencoder.SetLevel(responseWriter, 9)
+			},
+			acceptEncoding:  "level",
+			contentEncoding: "level",
+			responseBody:    "9:" + testString,
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+				if !iterTest.override(unwrapsWriter{responseWriter}) {
+					t.Error("middleware http.ResponseWriter not found")
+				}
+
+				_, _ = io.WriteString(responseWriter, testString)
+			})
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			httpencoder.New(encoders, nil)(handler).ServeHTTP(recorder, request)
+
+			response := recorder.Result()
+			defer response.Body.Close()
+
+			if response.Header.Get("Content-Encoding") != iterTest.contentEncoding {
+				strFormat := "invalid Content-Encoding header in response, want %s but got %s"
+				t.Fatalf(strFormat, iterTest.contentEncoding, response.Header.Get("Content-Encoding"))
+			}
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal("cannot Read response.Body after http request: " + err.Error())
+			}
+
+			if string(body) != iterTest.responseBody {
+				t.Fatalf("invalid response: want '%s' but got '%s'", iterTest.responseBody, body)
+			}
+		})
+	}
+}
+
+func TestOverrideWithoutMiddleware(test *testing.T) {
+	test.Parallel()
+
+	if httpencoder.Disable(httptest.NewRecorder()) {
+		test.Fatal("Disable must report missing middleware http.ResponseWriter")
+	}
+}
+
+type halver struct{}
+
+func (halver) Encode(ctx context.Context, to io.Writer, from []byte) error {
+	return repeater{}.Decode(ctx, to, from)
+}
+
+func TestMinSavings(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}, "half": halver{}}
+
+	tests := []struct {
+		testName        string
+		acceptEncoding  string
+		minSavings      float64
+		contentEncoding string
+	}{
+		{
+			testName:        "larger is skipped",
+			acceptEncoding:  "repeate",
+			minSavings:      0,
+			contentEncoding: "",
+		}, {
+			testName:        "smaller enough is sent",
+			acceptEncoding:  "half",
+			minSavings:      0.4,
+			contentEncoding: "half",
+		}, {
+			testName:        "not smaller enough is skipped",
+			acceptEncoding:  "half",
+			minSavings:      0.6,
+			contentEncoding: "",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			var skipped httpencoder.SkipReason
+
+			compress := httpencoder.New(encoders, nil,
+				httpencoder.WithMinSavings(iterTest.minSavings),
+				httpencoder.WithHooks(httpencoder.Hooks{
+					OnSkipped: func(_ context.Context, reason httpencoder.SkipReason) {
+						skipped = reason
+					},
+				}),
+			)
+
+			handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+				_, _ = io.WriteString(responseWriter, testString)
+			})
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			compress(handler).ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.contentEncoding {
+				strFormat := "invalid Content-Encoding header in response, want %s but got %s"
+				t.Fatalf(strFormat, iterTest.contentEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			if iterTest.contentEncoding == "" && (recorder.Body.String() != testString || skipped != httpencoder.SkipNotSmaller) {
+				t.Fatalf("invalid skipped response: reason %s, body '%s'", skipped, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+// Package permessagedeflate implements "permessage-deflate" WebSocket
+// extension (RFC 7692): negotiation of its parameters during opening
+// handshake and per-message compression built on compress/flate.
+//
+// httpencoder middleware leaves requests with Upgrade header untouched, so
+// WebSocket handler behind it negotiates extension with Negotiate, sets
+// returned Sec-WebSocket-Extensions response header and then compresses
+// payload of outgoing messages with Compressor and decompresses payload of
+// incoming messages with RSV1 bit set with Decompressor, regardless of
+// WebSocket implementation working with hijacked connection.
+package permessagedeflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Config is server side configuration of extension.
+	Config struct {
+		// ServerNoContextTakeover makes server compress every message
+		// independently, so Compressor holds no window between messages.
+		ServerNoContextTakeover bool
+		// ClientNoContextTakeover asks client to compress every message
+		// independently, so Decompressor holds no window between messages.
+		ClientNoContextTakeover bool
+	}
+
+	// Extension is result of negotiation.
+	Extension struct {
+		// ServerNoContextTakeover means server must not reuse window between messages.
+		ServerNoContextTakeover bool
+		// ClientNoContextTakeover means client does not reuse window between messages.
+		ClientNoContextTakeover bool
+		// ServerMaxWindowBits is server window size limit offered by client
+		// and accepted by server, or zero if client did not offer it.
+		ServerMaxWindowBits int
+	}
+
+	// Compressor compresses message payloads. It is not safe for concurrent use.
+	Compressor struct {
+		writer          *flate.Writer
+		buffer          bytes.Buffer
+		contextTakeover bool
+	}
+
+	// Decompressor decompresses message payloads. It is not safe for concurrent use.
+	Decompressor struct {
+		reader          io.ReadCloser
+		window          []byte
+		maxMessageSize  int64
+		contextTakeover bool
+	}
+)
+
+const (
+	// ExtensionName is name of extension in Sec-WebSocket-Extensions header.
+	ExtensionName = "permessage-deflate"
+
+	serverNoContextTakeover = "server_no_context_takeover"
+	clientNoContextTakeover = "client_no_context_takeover"
+	serverMaxWindowBits     = "server_max_window_bits"
+	clientMaxWindowBits     = "client_max_window_bits"
+
+	minWindowBits = 8
+	maxWindowBits = 15
+	windowSize    = 1 << maxWindowBits
+)
+
+var (
+	// ErrMessageTooLarge is returned by Decompressor when decompressed
+	// message exceeds its limit.
+	ErrMessageTooLarge = errors.New("permessagedeflate: message too large")
+
+	// syncFlushTail is removed from end of compressed message by sender
+	// and appended back by receiver, see RFC 7692 section 7.2.
+	syncFlushTail = []byte{0x00, 0x00, 0xff, 0xff}
+	// finalBlock is empty final stored block, which makes flate reader
+	// return io.EOF right after message instead of waiting for more data.
+	finalBlock = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+// Negotiate picks the first acceptable permessage-deflate offer of client
+// from request Sec-WebSocket-Extensions header and returns negotiated
+// Extension with value for Sec-WebSocket-Extensions response header.
+// Offers limiting server window below 32K are declined, because
+// compress/flate always uses full window. Negotiate reports whether any
+// offer was accepted.
+func Negotiate(header http.Header, config Config) (Extension, string, bool) {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(value, ",") {
+			extension, okay := parseOffer(offer, config)
+			if okay {
+				return extension, extension.String(), true
+			}
+		}
+	}
+
+	return Extension{}, "", false
+}
+
+// String returns extension as value of Sec-WebSocket-Extensions response header.
+func (extension Extension) String() string {
+	value := ExtensionName
+
+	if extension.ServerNoContextTakeover {
+		value += "; " + serverNoContextTakeover
+	}
+
+	if extension.ClientNoContextTakeover {
+		value += "; " + clientNoContextTakeover
+	}
+
+	// offered server_max_window_bits is accepted only by echoing it, RFC 7692 section 7.1.2.1
+	if extension.ServerMaxWindowBits > 0 {
+		value += "; " + serverMaxWindowBits + "=" + strconv.Itoa(extension.ServerMaxWindowBits)
+	}
+
+	return value
+}
+
+func parseOffer(offer string, config Config) (Extension, bool) {
+	params := strings.Split(offer, ";")
+	if !strings.EqualFold(strings.TrimSpace(params[0]), ExtensionName) {
+		return Extension{}, false
+	}
+
+	extension := Extension{
+		ServerNoContextTakeover: config.ServerNoContextTakeover,
+		ClientNoContextTakeover: config.ClientNoContextTakeover,
+		ServerMaxWindowBits:     0,
+	}
+	seen := make(map[string]bool, len(params)-1)
+
+	for _, param := range params[1:] {
+		name, value, hasValue := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+
+		if seen[name] {
+			return Extension{}, false
+		}
+
+		seen[name] = true
+
+		switch name {
+		case serverNoContextTakeover:
+			if hasValue {
+				return Extension{}, false
+			}
+
+			extension.ServerNoContextTakeover = true
+		case clientNoContextTakeover:
+			if hasValue {
+				return Extension{}, false
+			}
+
+			extension.ClientNoContextTakeover = true
+		case serverMaxWindowBits:
+			bits, valid := parseWindowBits(value)
+			if !valid || bits < maxWindowBits {
+				return Extension{}, false
+			}
+
+			extension.ServerMaxWindowBits = bits
+		case clientMaxWindowBits:
+			// any client window fits into window of Decompressor
+			if _, valid := parseWindowBits(value); hasValue && !valid {
+				return Extension{}, false
+			}
+		default:
+			return Extension{}, false
+		}
+	}
+
+	return extension, true
+}
+
+func parseWindowBits(value string) (int, bool) {
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits {
+		return 0, false
+	}
+
+	return bits, true
+}
+
+// NewCompressor returns Compressor with compress/flate level. Server passes
+// !Extension.ServerNoContextTakeover as contextTakeover, client passes
+// !Extension.ClientNoContextTakeover.
+func NewCompressor(level int, contextTakeover bool) (*Compressor, error) {
+	compressor := &Compressor{
+		writer:          nil,
+		buffer:          bytes.Buffer{},
+		contextTakeover: contextTakeover,
+	}
+
+	writer, err := flate.NewWriter(&compressor.buffer, level)
+	if err != nil {
+		return nil, fmt.Errorf("permessagedeflate: %w", err)
+	}
+
+	compressor.writer = writer
+
+	return compressor, nil
+}
+
+// Compress returns compressed payload of message, which is sent with RSV1 bit set.
+func (compressor *Compressor) Compress(message []byte) ([]byte, error) {
+	compressor.buffer.Reset()
+
+	if !compressor.contextTakeover {
+		compressor.writer.Reset(&compressor.buffer)
+	}
+
+	_, err := compressor.writer.Write(message)
+	if err != nil {
+		return nil, fmt.Errorf("permessagedeflate: %w", err)
+	}
+
+	err = compressor.writer.Flush()
+	if err != nil {
+		return nil, fmt.Errorf("permessagedeflate: %w", err)
+	}
+
+	compressed := bytes.TrimSuffix(compressor.buffer.Bytes(), syncFlushTail)
+
+	return append([]byte(nil), compressed...), nil
+}
+
+// NewDecompressor returns Decompressor, which rejects messages decompressed
+// into more than maxMessageSize bytes, zero means no limit. Server passes
+// !Extension.ClientNoContextTakeover as contextTakeover, client passes
+// !Extension.ServerNoContextTakeover.
+func NewDecompressor(contextTakeover bool, maxMessageSize int64) *Decompressor {
+	return &Decompressor{
+		reader:          flate.NewReader(bytes.NewReader(nil)),
+		window:          nil,
+		maxMessageSize:  maxMessageSize,
+		contextTakeover: contextTakeover,
+	}
+}
+
+// Decompress returns payload of message received with RSV1 bit set.
+func (decompressor *Decompressor) Decompress(payload []byte) ([]byte, error) {
+	compressed := make([]byte, 0, len(payload)+len(syncFlushTail)+len(finalBlock))
+	compressed = append(append(append(compressed, payload...), syncFlushTail...), finalBlock...)
+
+	resetter, okay := decompressor.reader.(flate.Resetter)
+	if !okay {
+		panic("permessagedeflate: unreachable code")
+	}
+
+	err := resetter.Reset(bytes.NewReader(compressed), decompressor.window)
+	if err != nil {
+		return nil, fmt.Errorf("permessagedeflate: %w", err)
+	}
+
+	var reader io.Reader = decompressor.reader
+	if decompressor.maxMessageSize > 0 {
+		reader = io.LimitReader(reader, decompressor.maxMessageSize+1)
+	}
+
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("permessagedeflate: %w", err)
+	}
+
+	if decompressor.maxMessageSize > 0 && int64(len(message)) > decompressor.maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+
+	if decompressor.contextTakeover {
+		decompressor.window = append(decompressor.window, message...)
+		if len(decompressor.window) > windowSize {
+			decompressor.window = append([]byte(nil), decompressor.window[len(decompressor.window)-windowSize:]...)
+		}
+	}
+
+	return message, nil
+}
@@ -0,0 +1,176 @@
+package permessagedeflate_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder/permessagedeflate"
+)
+
+func TestNegotiate(test *testing.T) {
+	test.Parallel()
+
+	tests := []struct {
+		testName   string
+		offers     []string
+		config     permessagedeflate.Config
+		accepted   bool
+		extensions string
+	}{
+		{
+			testName:   "no offers",
+			offers:     nil,
+			config:     permessagedeflate.Config{},
+			accepted:   false,
+			extensions: "",
+		}, {
+			testName:   "plain offer",
+			offers:     []string{"permessage-deflate"},
+			config:     permessagedeflate.Config{},
+			accepted:   true,
+			extensions: "permessage-deflate",
+		}, {
+			testName:   "client parameters",
+			offers:     []string{"permessage-deflate; client_max_window_bits; client_no_context_takeover"},
+			config:     permessagedeflate.Config{},
+			accepted:   true,
+			extensions: "permessage-deflate; client_no_context_takeover",
+		}, {
+			testName:   "server configuration",
+			offers:     []string{"x-webkit-deflate-frame", "permessage-deflate; client_max_window_bits=\"10\""},
+			config:     permessagedeflate.Config{ServerNoContextTakeover: true, ClientNoContextTakeover: true},
+			accepted:   true,
+			extensions: "permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		}, {
+			testName:   "small server window is declined in favor of fallback offer",
+			offers:     []string{"permessage-deflate; server_max_window_bits=10, permessage-deflate; server_no_context_takeover"},
+			config:     permessagedeflate.Config{},
+			accepted:   true,
+			extensions: "permessage-deflate; server_no_context_takeover",
+		}, {
+			testName:   "full server window is echoed",
+			offers:     []string{"permessage-deflate; server_max_window_bits=15"},
+			config:     permessagedeflate.Config{},
+			accepted:   true,
+			extensions: "permessage-deflate; server_max_window_bits=15",
+		}, {
+			testName:   "invalid offers",
+			offers:     []string{"permessage-deflate; unknown, permessage-deflate; client_max_window_bits=16", "permessage-deflate; server_no_context_takeover; server_no_context_takeover"},
+			config:     permessagedeflate.Config{},
+			accepted:   false,
+			extensions: "",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			header := http.Header{}
+			for _, offer := range iterTest.offers {
+				header.Add("Sec-WebSocket-Extensions", offer)
+			}
+
+			_, extensions, accepted := permessagedeflate.Negotiate(header, iterTest.config)
+			if accepted != iterTest.accepted || extensions != iterTest.extensions {
+				t.Fatalf("invalid negotiation, want %v '%s' but got %v '%s'", iterTest.accepted, iterTest.extensions, accepted, extensions)
+			}
+		})
+	}
+}
+
+// Examples from RFC 7692 section 7.2.3.
+func TestRFCExamples(test *testing.T) {
+	test.Parallel()
+
+	decompressor := permessagedeflate.NewDecompressor(true, 0)
+
+	for _, payload := range [][]byte{
+		{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00},
+		{0xf2, 0x00, 0x11, 0x00, 0x00},
+		{0x00, 0x05, 0x00, 0xfa, 0xff, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x00},
+	} {
+		message, err := decompressor.Decompress(payload)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		if string(message) != "Hello" {
+			test.Fatalf("invalid decompressed message '%s'", message)
+		}
+	}
+
+	compressor, err := permessagedeflate.NewCompressor(flate.BestCompression, false)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	compressed, err := compressor.Compress([]byte("Hello"))
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if !bytes.Equal(compressed, []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00}) {
+		test.Fatalf("invalid compressed message %x", compressed)
+	}
+}
+
+func TestRoundTrip(test *testing.T) {
+	test.Parallel()
+
+	for _, contextTakeover := range []bool{true, false} {
+		compressor, err := permessagedeflate.NewCompressor(flate.DefaultCompression, contextTakeover)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		decompressor := permessagedeflate.NewDecompressor(contextTakeover, 1<<20)
+
+		var firstSize int
+
+		message := &strings.Builder{}
+		for i := 0; i < 1000; i++ {
+			message.WriteString(strconv.Itoa(i * i * i))
+		}
+
+		for i := 0; i < 3; i++ {
+
+			compressed, err := compressor.Compress([]byte(message.String()))
+			if err != nil {
+				test.Fatal(err)
+			}
+
+			if i == 0 {
+				firstSize = len(compressed)
+			}
+
+			if contextTakeover && i > 0 && len(compressed) >= firstSize/2 {
+				test.Fatalf("context takeover does not shrink repeated message: %d of %d", len(compressed), firstSize)
+			}
+
+			decompressed, err := decompressor.Decompress(compressed)
+			if err != nil {
+				test.Fatal(err)
+			}
+
+			if string(decompressed) != message.String() {
+				test.Fatalf("invalid round trip with context takeover %v", contextTakeover)
+			}
+		}
+	}
+
+	compressor, _ := permessagedeflate.NewCompressor(flate.DefaultCompression, false)
+	compressed, _ := compressor.Compress(make([]byte, 1024))
+
+	_, err := permessagedeflate.NewDecompressor(false, 1023).Decompress(compressed)
+	if !errors.Is(err, permessagedeflate.ErrMessageTooLarge) {
+		test.Fatalf("invalid error of too large message, want %v but got %v", permessagedeflate.ErrMessageTooLarge, err)
+	}
+}
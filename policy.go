@@ -0,0 +1,115 @@
+package httpencoder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+type (
+	// Policy overrides middleware settings for single request.
+	// Zero fields keep middleware settings.
+	Policy struct {
+		// Encoders replace encoders passed to New and WithMediaTypeEncoders.
+		Encoders map[string]Encoder
+		// Decoders replace decoders passed to New.
+		Decoders map[string]Decoder
+		// MaxRequestBodySize limits size of request body as it is sent by client.
+		MaxRequestBodySize int64
+		// MaxDecodedSize limits size of decoded request body.
+		MaxDecodedSize int64
+		// MinSize is minimal response body size to encode.
+		MinSize int
+		// DisableEncode turns off response encoding.
+		DisableEncode bool
+		// DisableDecode turns off request decoding.
+		DisableDecode bool
+	}
+
+	// PolicyMux maps http.ServeMux patterns to Policies, so method, host and
+	// path of request are matched the same way as by http.ServeMux.
+	PolicyMux struct {
+		mux      *http.ServeMux
+		policies map[string]Policy
+	}
+
+	limitedWriter struct {
+		buffer    io.Writer
+		remaining int64
+	}
+)
+
+// ErrTooLarge is returned when body exceeds Policy limits.
+var ErrTooLarge = errors.New("httpencoder: body too large")
+
+// WithPolicy makes middleware apply Policy returned by policy for each
+// request, so one middleware could serve routes with different codecs,
+// limits and thresholds.
+func WithPolicy(policy func(request *http.Request) Policy) Option {
+	return func(cfg *config) {
+		cfg.policy = policy
+	}
+}
+
+// NewPolicyMux returns empty PolicyMux.
+func NewPolicyMux() *PolicyMux {
+	return &PolicyMux{
+		mux:      http.NewServeMux(),
+		policies: map[string]Policy{},
+	}
+}
+
+// Handle registers Policy for http.ServeMux pattern like "POST /webhooks/".
+// As http.ServeMux.Handle it panics on invalid or conflicting pattern.
+func (policyMux *PolicyMux) Handle(pattern string, policy Policy) {
+	policyMux.mux.Handle(pattern, http.NotFoundHandler())
+	policyMux.policies[pattern] = policy
+}
+
+// Policy returns Policy for the most specific pattern matched by request,
+// or zero Policy if nothing matches. It is intended to be passed to WithPolicy.
+func (policyMux *PolicyMux) Policy(request *http.Request) Policy {
+	_, pattern := policyMux.mux.Handler(request)
+
+	return policyMux.policies[pattern]
+}
+
+// resolvePolicy returns Policy of request, computing it once per request.
+func (cfg *config) resolvePolicy(request *http.Request) (*http.Request, *Policy) {
+	if cfg.policy == nil {
+		return request, &Policy{}
+	}
+
+	if policy, okay := request.Context().Value(policyKey).(*Policy); okay {
+		return request, policy
+	}
+
+	policy := cfg.policy(request)
+
+	return request.WithContext(context.WithValue(request.Context(), policyKey, &policy)), &policy
+}
+
+//nolint:wrapcheck // there is simple limiting wrapper, no need to wrap
+func (writer *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > writer.remaining {
+		return 0, ErrTooLarge
+	}
+
+	writer.remaining -= int64(len(p))
+
+	return writer.buffer.Write(p)
+}
+
+func (cfg *config) limited(request *http.Request, coding string, limit int64) {
+	if cfg.hooks.OnError != nil {
+		cfg.hooks.OnError(request.Context(), coding, ErrTooLarge)
+	}
+
+	cfg.log(request, cfg.logLevels.Limit, "httpencoder: body size limit exceeded",
+		slog.String("coding", coding),
+		slog.Int64("limit", limit),
+		slog.Int("status", http.StatusRequestEntityTooLarge),
+	)
+}
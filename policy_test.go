@@ -0,0 +1,96 @@
+package httpencoder_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func TestPolicyMux(test *testing.T) {
+	test.Parallel()
+
+	policies := httpencoder.NewPolicyMux()
+	policies.Handle("GET /download/", httpencoder.Policy{DisableEncode: true})
+	policies.Handle("POST /upload", httpencoder.Policy{MaxDecodedSize: 3})
+	policies.Handle("POST /raw", httpencoder.Policy{MaxRequestBodySize: 3})
+	policies.Handle("/small", httpencoder.Policy{MinSize: 100})
+	policies.Handle("POST /webhook", httpencoder.Policy{DisableDecode: true})
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	compress := httpencoder.New(encoders, decoders, httpencoder.WithPolicy(policies.Policy))
+
+	tests := []struct {
+		testName           string
+		method             string
+		target             string
+		responseEncoding   string
+		responseStatusCode int
+	}{
+		{
+			testName:           "not matched route",
+			method:             http.MethodPost,
+			target:             "/",
+			responseEncoding:   "repeate",
+			responseStatusCode: returnedStatusCode,
+		}, {
+			testName:           "encode disabled",
+			method:             http.MethodGet,
+			target:             "/download/file",
+			responseEncoding:   "",
+			responseStatusCode: returnedStatusCode,
+		}, {
+			testName:           "decoded body limit",
+			method:             http.MethodPost,
+			target:             "/upload",
+			responseEncoding:   "repeate",
+			responseStatusCode: http.StatusRequestEntityTooLarge,
+		}, {
+			testName:           "request body limit",
+			method:             http.MethodPost,
+			target:             "/raw",
+			responseEncoding:   "repeate",
+			responseStatusCode: http.StatusRequestEntityTooLarge,
+		}, {
+			testName:           "small response",
+			method:             http.MethodPost,
+			target:             "/small",
+			responseEncoding:   "",
+			responseStatusCode: returnedStatusCode,
+		}, {
+			testName:           "decode disabled",
+			method:             http.MethodPost,
+			target:             "/webhook",
+			responseEncoding:   "repeate",
+			responseStatusCode: returnedStatusCode,
+		},
+	}
+
+	for _, iterTest := range tests {
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(iterTest.method, iterTest.target, strings.NewReader("aabbccdd"))
+			request.Header.Set("Content-Encoding", "repeate")
+			request.Header.Set("Accept-Encoding", "repeate")
+
+			compress(handlerWithoutEncoding).ServeHTTP(recorder, request)
+
+			response := recorder.Result()
+			defer response.Body.Close()
+
+			if response.StatusCode != iterTest.responseStatusCode {
+				t.Fatalf("unexpected response status code, want %d but got %d", iterTest.responseStatusCode, response.StatusCode)
+			}
+
+			if response.Header.Get("Content-Encoding") != iterTest.responseEncoding {
+				strFormat := "invalid Content-Encoding header in response, want %s but got %s"
+				t.Fatalf(strFormat, iterTest.responseEncoding, response.Header.Get("Content-Encoding"))
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package httpencoder
+
+import (
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+)
+
+const (
+	dialTimeout         = 30 * time.Second
+	keepAlive           = 30 * time.Second
+	maxIdleConns        = 100
+	idleConnTimeout     = 90 * time.Second
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// NewReverseProxy returns httputil.ReverseProxy to target, which keeps
+// bodies encoded end to end: it forwards client's Accept-Encoding and
+// Content-Encoding upstream as is, and its transport neither asks upstream
+// for gzip nor decompresses responses itself. Serve it behind middleware
+// created with WithProxy.
+//
+//nolint:exhaustruct // zero values are defaults of http.Transport and httputil.ReverseProxy
+func NewReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	// settings of http.DefaultTransport, which could be replaced by application
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(proxyRequest *httputil.ProxyRequest) {
+			proxyRequest.SetURL(target)
+			proxyRequest.SetXForwarded()
+		},
+		Transport: transport,
+	}
+}
+
+// WithProxy makes middleware suitable for reverse proxy: request bodies
+// are forwarded without decoding, upstream responses encoded with coding
+// accepted by client are sent as is, and other ones are transcoded, see
+// WithTranscoding. Use Policy with DisableDecode instead to proxy only
+// some of routes.
+func WithProxy() Option {
+	return func(cfg *config) {
+		cfg.proxy = true
+		cfg.transcode = true
+		cfg.reencode = true
+	}
+}
@@ -0,0 +1,216 @@
+package httpencoder_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func TestReverseProxy(test *testing.T) {
+	test.Parallel()
+
+	// legacy upstream always encodes responses with its own coding
+	upstream := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+
+		responseWriter.Header().Set("X-Request-Encoding", request.Header.Get("Content-Encoding"))
+		responseWriter.Header().Set("X-Accept-Encoding", request.Header.Get("Accept-Encoding"))
+		responseWriter.Header().Set("Content-Encoding", "repeate")
+
+		if request.Header.Get("X-Streaming") == "" {
+			_ = repeater{}.Encode(request.Context(), responseWriter, body)
+
+			return
+		}
+
+		// chunked response without Content-Length is flushed by proxy after every write
+		for _, char := range body {
+			_ = repeater{}.Encode(request.Context(), responseWriter, []byte{char})
+
+			responseWriter.(http.Flusher).Flush()
+		}
+	}))
+	test.Cleanup(upstream.Close)
+
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}, "quadro": repeater2{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	proxy := httpencoder.New(encoders, decoders, httpencoder.WithProxy())(httpencoder.NewReverseProxy(target))
+
+	repeated := &strings.Builder{}
+	quadro := &strings.Builder{}
+
+	_ = repeater{}.Encode(context.Background(), repeated, []byte(testString))
+	_ = repeater2{}.Encode(context.Background(), quadro, []byte(testString))
+
+	tests := []struct {
+		testName         string
+		acceptEncoding   string
+		requestEncoding  string
+		requestBody      string
+		streaming        bool
+		responseEncoding string
+		responseBody     string
+	}{
+		{
+			testName:         "accepted upstream coding is passed through",
+			acceptEncoding:   "repeate",
+			requestEncoding:  "",
+			requestBody:      testString,
+			streaming:        false,
+			responseEncoding: "repeate",
+			responseBody:     repeated.String(),
+		}, {
+			testName:         "not accepted upstream coding is transcoded",
+			acceptEncoding:   "quadro",
+			requestEncoding:  "",
+			requestBody:      testString,
+			streaming:        false,
+			responseEncoding: "quadro",
+			responseBody:     quadro.String(),
+		}, {
+			testName:         "encoded request body is forwarded",
+			acceptEncoding:   "",
+			requestEncoding:  "repeate",
+			requestBody:      repeated.String(),
+			streaming:        false,
+			responseEncoding: "",
+			responseBody:     repeated.String(),
+		}, {
+			testName:         "streamed upstream coding is passed through",
+			acceptEncoding:   "repeate",
+			requestEncoding:  "",
+			requestBody:      testString,
+			streaming:        true,
+			responseEncoding: "repeate",
+			responseBody:     repeated.String(),
+		}, {
+			testName:         "streamed upstream coding is transcoded",
+			acceptEncoding:   "quadro",
+			requestEncoding:  "",
+			requestBody:      testString,
+			streaming:        true,
+			responseEncoding: "quadro",
+			responseBody:     quadro.String(),
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(iterTest.requestBody))
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+			request.Header.Set("Content-Encoding", iterTest.requestEncoding)
+
+			if iterTest.streaming {
+				request.Header.Set("X-Streaming", "1")
+			}
+
+			proxy.ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("X-Accept-Encoding") != iterTest.acceptEncoding {
+				t.Fatalf("invalid Accept-Encoding header forwarded, want %s but got %s",
+					iterTest.acceptEncoding, recorder.Header().Get("X-Accept-Encoding"))
+			}
+
+			if recorder.Header().Get("X-Request-Encoding") != iterTest.requestEncoding {
+				t.Fatalf("invalid Content-Encoding header forwarded, want %s but got %s",
+					iterTest.requestEncoding, recorder.Header().Get("X-Request-Encoding"))
+			}
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.responseEncoding {
+				t.Fatalf("invalid Content-Encoding header in response, want %s but got %s",
+					iterTest.responseEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			if recorder.Body.String() != iterTest.responseBody {
+				t.Fatalf("invalid response body, want %s but got %s", iterTest.responseBody, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestReverseProxyUpgrade(test *testing.T) {
+	test.Parallel()
+
+	// upstream switches to echo protocol over hijacked connection
+	upstream := httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+		conn, buffered, err := http.NewResponseController(responseWriter).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = buffered.Flush()
+
+		message := make([]byte, len(testString))
+		if _, err = io.ReadFull(buffered, message); err == nil {
+			_, _ = conn.Write(message)
+		}
+	}))
+	test.Cleanup(upstream.Close)
+
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}}
+	proxy := httptest.NewServer(httpencoder.New(encoders, nil, httpencoder.WithProxy())(httpencoder.NewReverseProxy(target)))
+	test.Cleanup(proxy.Close)
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		test.Fatalf("unexpected response status code, want %d but got %d", http.StatusSwitchingProtocols, response.StatusCode)
+	}
+
+	_, err = io.WriteString(conn, testString)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	echoed := make([]byte, len(testString))
+
+	_, err = io.ReadFull(reader, echoed)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	if string(echoed) != testString {
+		test.Fatalf("invalid echoed message, want %s but got %s", testString, echoed)
+	}
+}
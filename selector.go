@@ -0,0 +1,56 @@
+package httpencoder
+
+type (
+	// Selector picks response content coding and compression level among
+	// registered codings accepted by client, ordered by client preference,
+	// based on buffered body size and its Content-Type. Returned coding which
+	// is not acceptable is ignored and negotiated one is used. Return
+	// DefaultLevel to use Encoder's default compression level.
+	Selector func(acceptable []Preference, size int, contentType string) (coding string, level int)
+
+	// SizeRule routes responses up to MaxSize bytes to first acceptable coding
+	// from Codings with Level. Zero MaxSize matches responses of any size.
+	SizeRule struct {
+		Codings []string
+		MaxSize int
+		Level   int
+	}
+)
+
+// WithSelector makes middleware choose response encoding with selector after
+// handler call. Encoding forced by handler with Force takes precedence, and so
+// does level set with SetLevel.
+func WithSelector(selector Selector) Option {
+	return func(cfg *config) {
+		cfg.selector = selector
+	}
+}
+
+// SizeRules returns Selector which applies first rule matching response size
+// with at least one acceptable coding, e.g. gzip level 1 for small responses
+// and brotli level 9 for large ones:
+//
+//	httpencoder.SizeRules(
+//		httpencoder.SizeRule{MaxSize: 8 << 10, Codings: []string{"gzip"}, Level: 1},
+//		httpencoder.SizeRule{MaxSize: 1 << 20, Codings: []string{"br", "gzip"}, Level: httpencoder.DefaultLevel},
+//		httpencoder.SizeRule{MaxSize: 0, Codings: []string{"br"}, Level: 9},
+//	)
+func SizeRules(rules ...SizeRule) Selector {
+	return func(acceptable []Preference, size int, _ string) (string, int) {
+		for _, rule := range rules {
+			if rule.MaxSize != 0 && size > rule.MaxSize {
+				continue
+			}
+
+			for _, coding := range rule.Codings {
+				for _, preference := range acceptable {
+					if preference.Coding == coding {
+						return coding, rule.Level
+					}
+				}
+			}
+		}
+
+		return "", DefaultLevel
+	}
+}
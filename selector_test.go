@@ -0,0 +1,138 @@
+package httpencoder_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func TestSizeRules(test *testing.T) {
+	test.Parallel()
+
+	encoders := map[string]httpencoder.Encoder{"repeate": repeater{}, "level": leveler{}}
+	compress := httpencoder.New(encoders, nil, httpencoder.WithSelector(httpencoder.SizeRules(
+		httpencoder.SizeRule{MaxSize: 4, Codings: []string{"level"}, Level: 1},
+		httpencoder.SizeRule{MaxSize: 0, Codings: []string{"fake", "repeate"}, Level: httpencoder.DefaultLevel},
+	)))
+
+	tests := []struct {
+		testName        string
+		acceptEncoding  string
+		body            string
+		contentEncoding string
+		responseBody    string
+	}{
+		{
+			testName:        "small response",
+			acceptEncoding:  "repeate, level;q=0.1",
+			body:            "abc",
+			contentEncoding: "level",
+			responseBody:    "1:abc",
+		}, {
+			testName:        "large response",
+			acceptEncoding:  "level, repeate;q=0.1",
+			body:            "abcdef",
+			contentEncoding: "repeate",
+			responseBody:    "aabbccddeeff",
+		}, {
+			testName:        "no acceptable rule keeps negotiated coding",
+			acceptEncoding:  "level",
+			body:            "abcdef",
+			contentEncoding: "level",
+			responseBody:    "0:abcdef",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+				_, _ = io.WriteString(responseWriter, iterTest.body)
+			})
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			compress(handler).ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.contentEncoding {
+				strFormat := "invalid Content-Encoding header in response, want %s but got %s"
+				t.Fatalf(strFormat, iterTest.contentEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			if recorder.Body.String() != iterTest.responseBody {
+				t.Fatalf("invalid response: want '%s' but got '%s'", iterTest.responseBody, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestMediaTypeEncoders(test *testing.T) {
+	test.Parallel()
+
+	compress := httpencoder.New(nil, nil,
+		httpencoder.WithMediaTypeEncoders("application/json", map[string]httpencoder.Encoder{"quadro": repeater2{}}),
+		httpencoder.WithMediaTypeEncoders("text/*",
+			map[string]httpencoder.Encoder{"repeate": repeater{}, "level": leveler{}}, "level", "repeate"),
+	)
+
+	tests := []struct {
+		testName        string
+		contentType     string
+		acceptEncoding  string
+		contentEncoding string
+	}{
+		{
+			testName:        "exact media type",
+			contentType:     "application/json; charset=utf-8",
+			acceptEncoding:  "repeate, quadro;q=0.5",
+			contentEncoding: "quadro",
+		}, {
+			testName:        "wildcard media type with server preference",
+			contentType:     "text/html",
+			acceptEncoding:  "repeate, level, quadro",
+			contentEncoding: "level",
+		}, {
+			testName:        "client preference wins over server preference",
+			contentType:     "text/plain",
+			acceptEncoding:  "repeate, level;q=0.5",
+			contentEncoding: "repeate",
+		}, {
+			testName:        "not registered media type",
+			contentType:     "image/png",
+			acceptEncoding:  "repeate, level, quadro",
+			contentEncoding: "",
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			handler := http.HandlerFunc(func(responseWriter http.ResponseWriter, _ *http.Request) {
+				responseWriter.Header().Set("Content-Type", iterTest.contentType)
+				_, _ = io.WriteString(responseWriter, testString)
+			})
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+
+			compress(handler).ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.contentEncoding {
+				strFormat := "invalid Content-Encoding header in response, want %s but got %s"
+				t.Fatalf(strFormat, iterTest.contentEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+		})
+	}
+}
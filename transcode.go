@@ -0,0 +1,107 @@
+package httpencoder
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// WithTranscoding makes middleware decode response body, which handler has
+// already encoded with coding not accepted by client, like response of
+// legacy backend behind httputil.ReverseProxy, with registered Decoder.
+// If reencode is set, decoded body is encoded as usual into coding
+// preferred by client, otherwise it is sent as is. Strong ETag of such
+// response becomes weak. Responses are buffered to decode them, even if
+// client does not accept any of registered encoders.
+func WithTranscoding(reencode bool) Option {
+	return func(cfg *config) {
+		cfg.transcode = true
+		cfg.reencode = reencode
+	}
+}
+
+// transcodeUpstream decodes upstreamResponse in place if it is encoded by
+// handler with coding not accepted by client, and reports whether it was.
+func (cfg *config) transcodeUpstream(
+	request *http.Request, acceptEncodingHeader []byte, wrapped *wrappedWriter, upstreamResponse *bytes.Buffer,
+) (bool, error) {
+	header := wrapped.Header()
+
+	contentEncoding := header.Get("Content-Encoding")
+	if contentEncoding == "" || wrapped.disabled || hasCacheDirective(header, "no-transform") {
+		return false, nil
+	}
+
+	codings := parseContentEncoding(compactAndLow([]byte(contentEncoding)))
+	if len(codings) == 0 || acceptsCodings(acceptEncodingHeader, codings) {
+		return false, nil
+	}
+
+	_, policy := cfg.resolvePolicy(request)
+
+	decoders := cfg.decoders
+	if policy.Decoders != nil {
+		decoders = policy.Decoders
+	}
+
+	for _, coding := range codings {
+		if _, exist := decoders[coding]; !exist {
+			return false, nil
+		}
+	}
+
+	decodedBuffer := bufferGet(cfg.bufferPool)
+	defer bufferPut(cfg.bufferPool, decodedBuffer)
+
+	for last := len(codings) - 1; last >= 0; last-- {
+		decodedBuffer.Reset()
+
+		start := time.Now()
+
+		err := decoders[codings[last]].Decode(request.Context(), decodedBuffer, upstreamResponse.Bytes())
+		if err != nil {
+			header.Del("Content-Encoding")
+
+			cfg.failed(request, codings[last], upstreamResponse.Len(), err)
+
+			return false, err
+		}
+
+		cfg.transcoded(request.Context(), codings[last], upstreamResponse.Len(), decodedBuffer.Len(), time.Since(start))
+
+		upstreamResponse.Reset()
+		upstreamResponse.Write(decodedBuffer.Bytes())
+	}
+
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, weakETagPrefix) {
+		header.Set("ETag", weakETagPrefix+etag)
+	}
+
+	return true, nil
+}
+
+// acceptsCodings reports whether client accepts every coding of response.
+func acceptsCodings(acceptEncodingHeader []byte, codings []string) bool {
+	if acceptsEncoding(acceptEncodingHeader, "*") {
+		return true
+	}
+
+	for _, coding := range codings {
+		if coding != identityEncoding && !acceptsEncoding(acceptEncodingHeader, coding) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cfg *config) transcoded(ctx context.Context, coding string, inBytes, outBytes int, duration time.Duration) {
+	if cfg.hooks.OnTranscoded != nil {
+		cfg.hooks.OnTranscoded(ctx, coding, inBytes, outBytes, duration)
+	}
+}
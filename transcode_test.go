@@ -0,0 +1,119 @@
+package httpencoder_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexdyukov/httpencoder"
+)
+
+func TestTranscoding(test *testing.T) {
+	test.Parallel()
+
+	upstream := http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		responseWriter.Header().Set("ETag", `"v1"`)
+		responseWriter.Header().Set("Content-Encoding", "repeate")
+		responseWriter.Header().Set("Cache-Control", request.Header.Get("Cache-Control"))
+
+		_ = repeater{}.Encode(request.Context(), responseWriter, []byte(testString))
+	})
+
+	encoders := map[string]httpencoder.Encoder{"quadro": repeater2{}}
+	decoders := map[string]httpencoder.Decoder{"repeate": repeater{}}
+	repeated := &strings.Builder{}
+	quadro := &strings.Builder{}
+
+	_ = repeater{}.Encode(context.Background(), repeated, []byte(testString))
+	_ = repeater2{}.Encode(context.Background(), quadro, []byte(testString))
+
+	tests := []struct {
+		testName         string
+		acceptEncoding   string
+		cacheControl     string
+		opts             []httpencoder.Option
+		responseEncoding string
+		responseETag     string
+		responseBody     string
+	}{
+		{
+			testName:         "transcoding is disabled",
+			acceptEncoding:   "quadro",
+			cacheControl:     "",
+			opts:             nil,
+			responseEncoding: "repeate",
+			responseETag:     `"v1"`,
+			responseBody:     repeated.String(),
+		}, {
+			testName:         "accepted coding is kept",
+			acceptEncoding:   "repeate, quadro",
+			cacheControl:     "",
+			opts:             []httpencoder.Option{httpencoder.WithTranscoding(true)},
+			responseEncoding: "repeate",
+			responseETag:     `"v1"`,
+			responseBody:     repeated.String(),
+		}, {
+			testName:         "decoded for client without accept-encoding",
+			acceptEncoding:   "",
+			cacheControl:     "",
+			opts:             []httpencoder.Option{httpencoder.WithTranscoding(true)},
+			responseEncoding: "",
+			responseETag:     `W/"v1"`,
+			responseBody:     testString,
+		}, {
+			testName:         "decoded without reencoding",
+			acceptEncoding:   "quadro",
+			cacheControl:     "",
+			opts:             []httpencoder.Option{httpencoder.WithTranscoding(false)},
+			responseEncoding: "",
+			responseETag:     `W/"v1"`,
+			responseBody:     testString,
+		}, {
+			testName:         "reencoded into accepted coding",
+			acceptEncoding:   "quadro",
+			cacheControl:     "",
+			opts:             []httpencoder.Option{httpencoder.WithTranscoding(true)},
+			responseEncoding: "quadro",
+			responseETag:     `W/"v1-quadro"`,
+			responseBody:     quadro.String(),
+		}, {
+			testName:         "no-transform response is kept",
+			acceptEncoding:   "quadro",
+			cacheControl:     "no-transform",
+			opts:             []httpencoder.Option{httpencoder.WithTranscoding(true)},
+			responseEncoding: "repeate",
+			responseETag:     `"v1"`,
+			responseBody:     repeated.String(),
+		},
+	}
+
+	for _, iterTest := range tests {
+		iterTest := iterTest
+
+		test.Run(iterTest.testName, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", iterTest.acceptEncoding)
+			request.Header.Set("Cache-Control", iterTest.cacheControl)
+
+			httpencoder.New(encoders, decoders, iterTest.opts...)(upstream).ServeHTTP(recorder, request)
+
+			if recorder.Header().Get("Content-Encoding") != iterTest.responseEncoding {
+				t.Fatalf("invalid Content-Encoding header in response, want %s but got %s",
+					iterTest.responseEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			if recorder.Header().Get("ETag") != iterTest.responseETag {
+				t.Fatalf("invalid ETag header in response, want %s but got %s", iterTest.responseETag, recorder.Header().Get("ETag"))
+			}
+
+			if recorder.Body.String() != iterTest.responseBody {
+				t.Fatalf("invalid response body, want %s but got %s", iterTest.responseBody, recorder.Body.String())
+			}
+		})
+	}
+}